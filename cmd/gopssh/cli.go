@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -9,26 +10,33 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/masahide/gopssh/pkg/pssh"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
 const (
-	schemaVersion = "1"
-	maxStdinSize  = 64 << 20
+	schemaVersion       = "1"
+	maxStdinSize        = 64 << 20
+	defaultMaxExpansion = 1024
+	hostsExecWaitDelay  = 5 * time.Second
 )
 
-var modernCommands = []string{"run", "doctor", "hosts", "config", "version", "completion", "help"}
+var modernCommands = []string{"run", "doctor", "hosts", "known-hosts", "config", "version", "completion", "help"}
 
 type usageError struct {
 	Code         string   `json:"code"`
@@ -63,9 +71,26 @@ func (v *stringList) Set(value string) error {
 type runOptions struct {
 	config       pssh.Config
 	hostsFile    string
+	hostsExec    string
+	hostsExecRun *hostsExecResult
 	hosts        stringList
+	groups       stringList
+	selects      stringList
+	excludes     stringList
+	excludeFile  string
+	excluded     []targetExclusion
 	identities   stringList
 	command      string
+	script       string
+	steps        stringList
+	batchSize    string
+	maxFail      string
+	canary       int
+	canaryHosts  stringList
+	yes          bool
+	// canaryPrompt asks on the terminal before the targets after the
+	// canaries start.
+	canaryPrompt bool
 	stdin        bool
 	stdinFile    string
 	dryRun       bool
@@ -76,10 +101,21 @@ type runOptions struct {
 	exitPolicy   string
 	legacyCrypto bool
 	identitySet  bool
+	userSet      bool
+	sshConfig    string
+	noSSHConfig  bool
+	jumps        stringList
 	agentProbe   func(string) error
-	kex          string
-	ciphers      string
-	macs         string
+	askPass      bool
+	passwordFile string
+	passwordEnv  string
+	prompt       pssh.PromptFunc
+
+	identityPassphraseFile string
+	certificates           stringList
+	kex                    string
+	ciphers                string
+	macs                   string
 }
 
 func isModern(args []string) bool {
@@ -129,9 +165,11 @@ func executeModern(ctx context.Context, args []string, stdin io.Reader, stdout,
 	case "run":
 		return runModern(ctx, args, stdin, stdout, stderr, jsonMode)
 	case "doctor":
-		return runDoctor(ctx, args, stdout, stderr, jsonMode)
+		return runDoctor(ctx, args, stdin, stdout, stderr, jsonMode)
 	case "hosts":
-		return runHosts(args, stdout, stderr, jsonMode)
+		return runHosts(ctx, args, stdout, stderr, jsonMode)
+	case "known-hosts":
+		return runKnownHosts(ctx, args, stdin, stdout, stderr, jsonMode)
 	case "config":
 		return runConfig(args, stdout, stderr, jsonMode)
 	case "version":
@@ -220,6 +258,7 @@ func runFlagSet(options *runOptions) (*flag.FlagSet, []string) {
 	fs.SetOutput(io.Discard)
 	fs.StringVar(&options.hostsFile, "hosts-file", "", "hosts file")
 	fs.StringVar(&options.hostsFile, "H", "", "hosts file")
+	fs.StringVar(&options.hostsExec, "hosts-exec", "", "dynamic inventory command")
 	fs.Var(&options.hosts, "host", "target")
 	fs.StringVar(&options.config.User, "user", options.config.User, "SSH user")
 	fs.StringVar(&options.config.User, "u", options.config.User, "SSH user")
@@ -229,11 +268,17 @@ func runFlagSet(options *runOptions) (*flag.FlagSet, []string) {
 	fs.Var(&options.identities, "identity", "identity file")
 	fs.Var(&options.identities, "i", "identity file")
 	fs.BoolVar(&options.config.IdentityFileOnly, "identities-only", false, "disable SSH Agent")
+	fs.BoolVar(&options.config.ForwardAgent, "forward-agent", false, "forward the SSH Agent")
+	fs.BoolVar(&options.config.ForwardAgent, "A", false, "forward the SSH Agent")
 	fs.DurationVar(&options.config.Timeout, "connect-timeout", options.config.Timeout, "connect timeout")
+	fs.DurationVar(&options.config.CommandTimeout, "command-timeout", 0, "per-command timeout")
+	fs.DurationVar(&options.config.Deadline, "deadline", 0, "whole-run timeout")
+	fs.DurationVar(&options.config.GracePeriod, "grace-period", options.config.GracePeriod, "wait after a signal before closing")
 	fs.BoolVar(&options.config.ShowHostName, "show-host", false, "show target")
 	fs.StringVar(&options.order, "order", options.order, "input or completion")
 	fs.StringVar(&options.color, "color", options.color, "auto, always, or never")
 	fs.BoolVar(&options.config.IgnoreHostKey, "insecure-ignore-host-key", false, "skip known_hosts verification")
+	registerHostKeyFlags(fs, options)
 	fs.BoolVar(&options.legacyCrypto, "legacy-crypto", false, "use legacy SSH algorithms")
 	fs.StringVar(&options.kex, "kex", "", "key exchange algorithms")
 	fs.StringVar(&options.ciphers, "ciphers", "", "ciphers")
@@ -247,16 +292,37 @@ func runFlagSet(options *runOptions) (*flag.FlagSet, []string) {
 	fs.StringVar(&options.outputDir, "output-dir", "", "save per-target output")
 	fs.StringVar(&options.exitPolicy, "exit-policy", options.exitPolicy, "first, any, or always-zero")
 	fs.StringVar(&options.command, "command", "", "literal remote shell command")
+	fs.StringVar(&options.script, "script", "", "file of commands run in order")
+	fs.Var(&options.steps, "step", "command run in order")
+	fs.BoolVar(&options.config.StopOnFailure, "stop-on-failure", false, "skip later steps after a failure")
+	fs.StringVar(&options.batchSize, "batch-size", "", "targets per batch, N or P%")
+	fs.StringVar(&options.maxFail, "max-fail", "", "failed targets tolerated, N or P%")
+	fs.DurationVar(&options.config.BatchPause, "batch-pause", 0, "pause between batches")
+	fs.IntVar(&options.canary, "canary", 0, "run the first N targets first")
+	fs.Var(&options.canaryHosts, "canary-host", "run matching targets first")
+	fs.BoolVar(&options.yes, "yes", false, "continue after the canaries without asking")
 	fs.BoolVar(&options.stdin, "stdin", false, "forward process stdin")
 	fs.StringVar(&options.stdinFile, "stdin-file", "", "forward file")
+	registerSSHConfigFlags(fs, options)
+	registerJumpFlags(fs, options)
+	registerProxyFlags(fs, options)
+	registerPasswordFlags(fs, options)
+	registerCertificateFlags(fs, options)
+	registerSelectionFlags(fs, options)
 	known := []string{
-		"--hosts-file", "-H", "--host", "--user", "-u", "--parallel", "-p",
-		"--max-agent-connections", "--identity", "-i", "--identities-only",
-		"--connect-timeout", "--show-host", "--order", "--color",
-		"--insecure-ignore-host-key", "--legacy-crypto", "--kex", "--ciphers",
+		"--hosts-file", "-H", "--hosts-exec", "--host", "--user", "-u", "--parallel", "-p",
+		"--max-agent-connections", "--identity", "-i", "--identities-only", "--forward-agent", "-A",
+		"--connect-timeout", "--command-timeout", "--deadline", "--grace-period", "--show-host", "--order", "--color",
+		"--insecure-ignore-host-key", "--host-ca-file", "--known-hosts", "--host-key-policy",
+		"--legacy-crypto", "--kex", "--ciphers",
 		"--macs", "--max-buffer-memory", "--max-spool-size", "--spool-dir",
 		"--debug", "--dry-run", "--json", "--output-dir", "--exit-policy",
-		"--command", "--stdin", "--stdin-file",
+		"--command", "--script", "--step", "--stop-on-failure", "--batch-size", "--max-fail",
+		"--batch-pause", "--canary", "--canary-host", "--yes", "--stdin", "--stdin-file",
+		"--ssh-config", "--no-ssh-config",
+		"--jump", "-J", "--proxy-command", "--proxy", "--group", "--select",
+		"--exclude", "--exclude-file", "--ask-pass", "--password-file", "--password-env",
+		"--identity-passphrase-file", "--certificate",
 	}
 	return fs, known
 }
@@ -280,10 +346,16 @@ func runModern(ctx context.Context, args []string, stdin io.Reader, stdout, stde
 		return renderUsageError(stdout, stderr, globalJSON || options.json, parseFlagError(err, []string{"gopssh", "run"}, known, runUsage()))
 	}
 	options.json = options.json || globalJSON
+	options.userSet = flagWasSet(fs, "user", "u")
+	applyProxyEnvironment(fs, &options, stderr)
 	if len(options.identities) == 0 {
 		options.identities = pssh.ToSlice(defaultIdentityFiles)
 	}
 	commandArgs := fs.Args()
+	if err := loadSteps(&options, len(commandArgs) > 0); err != nil {
+		return renderUsageError(stdout, stderr, options.json, err)
+	}
+	scripted := len(options.config.Steps) > 0
 	if options.command == "" && len(commandArgs) > 0 && !hasArgumentDelimiter(args) {
 		return renderUsageError(stdout, stderr, options.json, newUsageError(
 			"invalid_argument", "command arguments must follow --; use --command for a literal shell command",
@@ -296,12 +368,12 @@ func runModern(ctx context.Context, args []string, stdin io.Reader, stdout, stde
 			[]string{"gopssh", "run"}, "--command", nil, runUsage(),
 		))
 	}
-	if options.command == "" && len(commandArgs) == 0 {
+	if options.command == "" && len(commandArgs) == 0 && !scripted {
 		return renderUsageError(stdout, stderr, options.json, newUsageError(
 			"missing_argument", "remote command is required", []string{"gopssh", "run"}, "", nil, runUsage(),
 		))
 	}
-	if options.command == "" {
+	if options.command == "" && !scripted {
 		options.command = shellJoin(commandArgs)
 	}
 	if err := validateRunOptions(&options); err != nil {
@@ -309,18 +381,51 @@ func runModern(ctx context.Context, args []string, stdin io.Reader, stdout, stde
 			"invalid_argument", err.Error(), []string{"gopssh", "run"}, "", nil, runUsage(),
 		))
 	}
-	targets, err := loadTargets(options.hostsFile, options.hosts)
+	entries, excluded, err := loadTargets(ctx, &options, stdin)
 	if err != nil {
 		return renderUsageError(stdout, stderr, options.json, newUsageError(
 			"hosts_file_invalid", err.Error(), []string{"gopssh", "run"}, options.hostsFile, nil, runUsage(),
 		))
 	}
+	entries, unselected, err := selectEntries(entries, options.selects)
+	if err != nil {
+		return renderUsageError(stdout, stderr, options.json, newUsageError(
+			"invalid_argument", err.Error(), []string{"gopssh", "run"}, "--select", nil, runUsage(),
+		))
+	}
+	options.excluded = append(excluded, unselected...)
+	if len(entries) == 0 && len(options.excluded) > 0 {
+		message, token := fmt.Sprintf("no targets match --select; %d excluded", len(options.excluded)), "--select"
+		if len(unselected) == 0 {
+			message, token = fmt.Sprintf("every target is excluded; %d excluded", len(options.excluded)), "--exclude"
+		}
+		return renderUsageError(stdout, stderr, options.json, newUsageError(
+			"no_targets_selected", message, []string{"gopssh", "run"}, token, nil, runUsage(),
+		))
+	}
+	if err := resolveTargets(entries, options); err != nil {
+		return renderUsageError(stdout, stderr, options.json, newUsageError(
+			"ssh_config_invalid", err.Error(), []string{"gopssh", "run"}, options.sshConfig, nil, runUsage(),
+		))
+	}
+	targets := entryTargets(entries)
 	if len(targets) == 0 {
 		return renderUsageError(stdout, stderr, options.json, newUsageError(
 			"missing_argument", "at least one --hosts-file or --host target is required",
 			[]string{"gopssh", "run"}, "", nil, runUsage(),
 		))
 	}
+	canaries, err := canaryTargets(options, entries)
+	if err != nil {
+		token := "--canary"
+		if len(options.canaryHosts) > 0 {
+			token = "--canary-host"
+		}
+		return renderUsageError(stdout, stderr, options.json, newUsageError(
+			"invalid_argument", err.Error(), []string{"gopssh", "run"}, token, nil, runUsage(),
+		))
+	}
+	options.config.Canaries = canaries
 	stdinData, err := readStdin(options, stdin)
 	if err != nil {
 		return renderUsageError(stdout, stderr, options.json, newUsageError(
@@ -328,9 +433,11 @@ func runModern(ctx context.Context, args []string, stdin io.Reader, stdout, stde
 		))
 	}
 	options.config.Targets = targets
+	options.config.Hosts = entryHosts(entries)
 	options.config.Command = options.command
 	options.config.Stdin = stdinData
 	options.config.IdentFiles = options.identities
+	options.config.CertificateFiles = options.certificates
 	options.config.SortPrint = options.order == "input"
 	options.config.ColorMode = options.color != "never" && !options.json
 	options.config.ColorAlways = options.color == "always" && !options.json
@@ -338,22 +445,35 @@ func runModern(ctx context.Context, args []string, stdin io.Reader, stdout, stde
 	options.config.Stderr = stderr
 	options.config.ExitPolicy = options.exitPolicy
 	configureCrypto(&options.config, options.legacyCrypto, options.kex, options.ciphers, options.macs)
+	closePrompt, secretErr := loadSecrets(&options, !options.dryRun)
+	if secretErr != nil {
+		return renderCommandError(stdout, stderr, options.json, secretErr)
+	}
+	defer closePrompt()
 	if options.dryRun {
-		return printDryRun(options, targets, stdout)
+		return printDryRun(options, entries, stdout)
 	}
 	if err := preflightRun(options); err != nil {
 		return renderCommandError(stdout, stderr, options.json, err)
 	}
+	options.canaryPrompt = isTerminalWriter(stderr)
 	return executeRun(ctx, options, targets, stdout, stderr)
 }
 
 func preflightRun(options runOptions) *commandError {
 	if !options.config.IgnoreHostKey {
-		knownHosts := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
-		if err := pssh.ValidateHostKeyPolicy(false); err != nil {
+		for _, file := range options.config.HostCAFiles {
+			if _, err := pssh.ReadHostCAFile(file); err != nil {
+				return &commandError{
+					Code: "host_ca_invalid", Message: "--host-ca-file: " + err.Error(),
+					Details: map[string]any{"path": file},
+				}
+			}
+		}
+		if err := options.config.ValidateHostKeyPolicy(); err != nil {
 			return &commandError{
 				Code: "known_hosts_unavailable", Message: err.Error(),
-				Details: map[string]any{"path": knownHosts},
+				Details: map[string]any{"paths": options.config.EffectiveKnownHostsFiles()},
 			}
 		}
 	}
@@ -403,34 +523,810 @@ func validateRunOptions(options *runOptions) error {
 	if options.stdin && options.stdinFile != "" {
 		return fmt.Errorf("--stdin and --stdin-file are mutually exclusive")
 	}
+	if options.hostsFile == "-" && (options.stdin || options.stdinFile != "") {
+		return fmt.Errorf("--hosts-file - cannot be combined with --stdin or --stdin-file")
+	}
+	if err := applyBatchOptions(options); err != nil {
+		return err
+	}
+	if err := validateCanaryOptions(*options); err != nil {
+		return err
+	}
+	if options.config.CommandTimeout < 0 || options.config.Deadline < 0 || options.config.GracePeriod < 0 {
+		return fmt.Errorf("--command-timeout, --deadline and --grace-period must not be negative")
+	}
+	if options.config.ForwardAgent && options.config.SSHAuthSocket == "" {
+		return fmt.Errorf("--forward-agent requires an SSH Agent; SSH_AUTH_SOCK is not set")
+	}
+	if err := applyHostKeyPolicy(options); err != nil {
+		return err
+	}
+	return validateTargetOptions(*options)
+}
+
+func validateTargetOptions(options runOptions) error {
+	if err := validatePasswordOptions(options); err != nil {
+		return err
+	}
+	if _, err := targetSelector(options.selects); err != nil {
+		return err
+	}
+	for _, value := range options.excludes {
+		if _, err := pssh.ParseHostExclusions(value, ""); err != nil {
+			return fmt.Errorf("--exclude: %w", err)
+		}
+	}
+	for _, jump := range options.jumps {
+		if _, err := pssh.ParseJumpHosts(jump); err != nil {
+			return err
+		}
+	}
+	if len(options.jumps) > 0 && options.config.ProxyCommand != "" {
+		return fmt.Errorf("--jump and --proxy-command are mutually exclusive")
+	}
+	if options.config.Proxy != "" && options.config.Proxy != pssh.ProxyNone {
+		if _, err := pssh.ParseProxyURL(options.config.Proxy); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func loadTargets(hostsFile string, inline []string) ([]string, error) {
-	var targets []string
-	if hostsFile != "" {
-		if hostsFile == "-" {
-			return nil, errors.New("--hosts-file - is not supported; use a named file")
+// loadTargets collects the targets of --hosts-file, --hosts-exec and --host
+// in that order, then removes those matched by !host entries, --exclude and
+// --exclude-file. --hosts-file - reads stdin. The --hosts-exec program runs at
+// most once per options; later calls reuse its result.
+func loadTargets(ctx context.Context, options *runOptions, stdin io.Reader) ([]hostEntry, []targetExclusion, error) {
+	targets, err := collectTargets(ctx, options, stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+	exclusions, err := exclusionRules(*options)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, excluded := withoutExcluded(applyExclusions(targets, exclusions))
+	return targets, excluded, nil
+}
+
+func collectTargets(ctx context.Context, options *runOptions, stdin io.Reader) ([]hostEntry, error) {
+	var targets []hostEntry
+	add := func(source string, entries []hostEntry) error {
+		for _, entry := range entries {
+			if entry.Error != "" {
+				return fmt.Errorf("%s:%d: %s", source, entry.Line, entry.Error)
+			}
+			entry.Index = len(targets)
+			targets = append(targets, entry)
 		}
-		entries, err := parseHostEntries(hostsFile)
+		return nil
+	}
+	if options.hostsFile == "" && len(options.groups) > 0 {
+		return nil, errors.New("--group requires --hosts-file")
+	}
+	if options.hostsFile == "-" {
+		if len(options.groups) > 0 {
+			return nil, errors.New("--group requires an inventory file; --hosts-file - reads plain lines or JSON")
+		}
+		data, err := io.ReadAll(io.LimitReader(stdin, maxStdinSize+1))
 		if err != nil {
 			return nil, err
 		}
-		for _, entry := range entries {
+		if len(data) > maxStdinSize {
+			return nil, fmt.Errorf("hosts on stdin exceed the 64MiB limit")
+		}
+		entries, err := parseDynamicHosts(data)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		if err := add("stdin", entries); err != nil {
+			return nil, err
+		}
+	} else if options.hostsFile != "" {
+		entries, err := parseHostEntries(options.hostsFile, options.groups)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(options.hostsFile, entries); err != nil {
+			return nil, err
+		}
+	}
+	if options.hostsExec != "" {
+		if options.hostsExecRun == nil {
+			result, err := runHostsExec(ctx, options.hostsExec)
+			if err != nil {
+				return nil, err
+			}
+			options.hostsExecRun = result
+		}
+		if err := add(options.hostsExec, options.hostsExecRun.entries); err != nil {
+			return nil, err
+		}
+	}
+	for _, value := range options.hosts {
+		for _, entry := range parseHostLine(len(targets), value, 0) {
 			if entry.Error != "" {
-				return nil, fmt.Errorf("%s:%d: %s", hostsFile, entry.Line, entry.Error)
+				return nil, errors.New(entry.Error)
+			}
+			targets = append(targets, entry)
+		}
+	}
+	return targets, nil
+}
+
+// hostsExecResult records the single run of the --hosts-exec program.
+type hostsExecResult struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Targets  int    `json:"targets"`
+	entries  []hostEntry
+}
+
+// runHostsExec runs a dynamic inventory program through sh and parses its
+// output. A non-zero exit status is an error quoting the last stderr line.
+func runHostsExec(ctx context.Context, command string) (*hostsExecResult, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = hostsExecWaitDelay
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("--hosts-exec %q: %w", command, err)
+	}
+	data, readErr := io.ReadAll(io.LimitReader(pipe, maxStdinSize+1))
+	if len(data) > maxStdinSize {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("--hosts-exec %q: output exceeds the 64MiB limit", command)
+	}
+	if err := cmd.Wait(); err != nil {
+		message := err.Error()
+		if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+			message = lines[len(lines)-1]
+		}
+		return nil, fmt.Errorf("--hosts-exec %q exited with status %d: %s", command, cmd.ProcessState.ExitCode(), message)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("--hosts-exec %q: %w", command, readErr)
+	}
+	entries, err := parseDynamicHosts(data)
+	if err != nil {
+		return nil, fmt.Errorf("--hosts-exec %q: %w", command, err)
+	}
+	return &hostsExecResult{Command: command, ExitCode: cmd.ProcessState.ExitCode(), Targets: len(entries), entries: entries}, nil
+}
+
+// dynamicHost is one object of a JSON host list.
+type dynamicHost struct {
+	Host   string            `json:"host"`
+	Port   int               `json:"port"`
+	User   string            `json:"user"`
+	Labels map[string]string `json:"labels"`
+}
+
+// parseDynamicHosts reads the output of --hosts-exec or --hosts-file -: either
+// the plain hosts-file format or a JSON array whose items are target strings
+// or host objects. The line of a JSON entry is its 1-based position.
+func parseDynamicHosts(data []byte) ([]hostEntry, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' || !json.Valid(trimmed) {
+		return parseHostLines(bytes.NewReader(data))
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, err
+	}
+	var entries []hostEntry
+	for i, item := range items {
+		host := pssh.InventoryHost{Line: i + 1}
+		if err := json.Unmarshal(item, &host.Name); err != nil {
+			var object dynamicHost
+			decoder := json.NewDecoder(bytes.NewReader(item))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&object); err != nil {
+				return nil, fmt.Errorf("host %d: %w", host.Line, err)
+			}
+			if object.Host == "" {
+				return nil, fmt.Errorf("host %d: host is required", host.Line)
+			}
+			if object.Port < 0 || object.Port > 65535 {
+				return nil, fmt.Errorf("host %d: invalid port %d", host.Line, object.Port)
+			}
+			host.Name = object.Host
+			host.Vars = pssh.InventoryVars{User: object.User, Port: object.Port}
+			for _, name := range slices.Sorted(maps.Keys(object.Labels)) {
+				if err := host.Vars.SetLabel(name, object.Labels[name]); err != nil {
+					return nil, fmt.Errorf("host %d: %w", host.Line, err)
+				}
 			}
-			targets = append(targets, entry.Normalized)
 		}
+		entries = append(entries, newInventoryEntries(len(entries), host)...)
 	}
-	for _, value := range inline {
-		target, err := normalizeModernHost(value)
+	markDuplicates(entries)
+	return entries, nil
+}
+
+func entryTargets(entries []hostEntry) []string {
+	targets := make([]string, len(entries))
+	for i, entry := range entries {
+		targets[i] = entry.Normalized
+	}
+	return targets
+}
+
+func entryHosts(entries []hostEntry) []pssh.Host {
+	hosts := make([]pssh.Host, len(entries))
+	for i, entry := range entries {
+		hosts[i] = pssh.Host{
+			Target:         entry.Normalized,
+			Addr:           entry.Address,
+			User:           entry.User,
+			IdentFiles:     entry.IdentityFiles,
+			IdentitiesOnly: entry.IdentitiesOnly,
+			JumpHosts:      entry.jumps,
+			ProxyCommand:   entry.ProxyCommand,
+		}
+	}
+	return hosts
+}
+
+func registerSSHConfigFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.StringVar(&options.sshConfig, "ssh-config", "", "OpenSSH client configuration file")
+	fs.BoolVar(&options.noSSHConfig, "no-ssh-config", false, "do not read an OpenSSH client configuration file")
+}
+
+func registerSelectionFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.Var(&options.groups, "group", "inventory group; repeatable")
+	fs.Var(&options.selects, "select", "label selector; repeatable")
+	fs.Var(&options.excludes, "exclude", "excluded host or pattern; repeatable")
+	fs.StringVar(&options.excludeFile, "exclude-file", "", "file of excluded hosts")
+}
+
+// exclusionRules parses --exclude and --exclude-file. Entries of the file
+// follow the hosts-file format; a leading ! is optional.
+func exclusionRules(options runOptions) ([]pssh.HostExclusion, error) {
+	var exclusions []pssh.HostExclusion
+	for _, value := range options.excludes {
+		excluded, err := pssh.ParseHostExclusions(value, "--exclude "+value)
+		if err != nil {
+			return nil, fmt.Errorf("--exclude: %w", err)
+		}
+		exclusions = append(exclusions, excluded...)
+	}
+	if options.excludeFile == "" {
+		return exclusions, nil
+	}
+	file, err := os.Open(options.excludeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.SplitN(scanner.Text(), "#", 2)[0]
+		for _, value := range strings.Fields(text) {
+			value = strings.TrimPrefix(value, "!")
+			source := fmt.Sprintf("%s:%d", options.excludeFile, line)
+			excluded, err := pssh.ParseHostExclusions(value, source)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", source, err)
+			}
+			exclusions = append(exclusions, excluded...)
+		}
+	}
+	return exclusions, scanner.Err()
+}
+
+// applyExclusions turns the !host entries of hosts files into exclusions,
+// drops them, and marks every entry matched by an exclusion with its reason.
+// The remaining entries are renumbered.
+func applyExclusions(entries []hostEntry, exclusions []pssh.HostExclusion) []hostEntry {
+	result := make([]hostEntry, 0, len(entries))
+	var negated []pssh.HostExclusion
+	for _, entry := range entries {
+		if !entry.negated {
+			result = append(result, entry)
+			continue
+		}
+		if entry.Error == "" {
+			source := fmt.Sprintf("!%s on line %d", valueOr(entry.Pattern, entry.Original), entry.Line)
+			negated = append(negated, pssh.HostExclusion{Host: entry.Host, Port: entry.excludedPort(), Source: source})
+		}
+	}
+	exclusions = slices.Concat(negated, exclusions)
+	for i := range result {
+		result[i].Index = i
+		if exclusion, ok := pssh.MatchHostExclusion(exclusions, result[i].Normalized); ok && result[i].Error == "" {
+			result[i].Excluded = exclusion.Source
+		}
+	}
+	return result
+}
+
+// excludedPort is the port a !host entry is limited to, or 0 for any port.
+func (e hostEntry) excludedPort() int {
+	if _, _, err := net.SplitHostPort(e.Original); err != nil {
+		return 0
+	}
+	return e.Port
+}
+
+// withoutExcluded separates the entries marked by applyExclusions.
+func withoutExcluded(entries []hostEntry) ([]hostEntry, []targetExclusion) {
+	kept := make([]hostEntry, 0, len(entries))
+	var excluded []targetExclusion
+	for _, entry := range entries {
+		if entry.Excluded == "" {
+			entry.Index = len(kept)
+			kept = append(kept, entry)
+			continue
+		}
+		excluded = append(excluded, targetExclusion{Target: entry.Normalized, Line: entry.Line, Reason: entry.Excluded})
+	}
+	return kept, excluded
+}
+
+// targetSelector combines the --select expressions, which must all match.
+func targetSelector(selects []string) (pssh.Selector, error) {
+	var selector pssh.Selector
+	for _, expr := range selects {
+		parsed, err := pssh.ParseSelector(expr)
 		if err != nil {
 			return nil, err
 		}
-		targets = append(targets, target)
+		selector = append(selector, parsed...)
 	}
-	return targets, nil
+	return selector, nil
+}
+
+type targetExclusion struct {
+	Target string `json:"target"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// selectEntries keeps the entries whose labels match every --select
+// expression and explains why the others were excluded. Invalid entries are
+// kept so that their errors are still reported.
+func selectEntries(entries []hostEntry, selects []string) ([]hostEntry, []targetExclusion, error) {
+	selector, err := targetSelector(selects)
+	if err != nil || len(selector) == 0 {
+		return entries, nil, err
+	}
+	selected := make([]hostEntry, 0, len(entries))
+	var excluded []targetExclusion
+	for _, entry := range entries {
+		reason := selector.Explain(entry.Labels)
+		if reason == "" || entry.Error != "" {
+			selected = append(selected, entry)
+			continue
+		}
+		excluded = append(excluded, targetExclusion{Target: entry.Normalized, Line: entry.Line, Reason: reason})
+	}
+	return selected, excluded, nil
+}
+
+func registerJumpFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.Var(&options.jumps, "jump", "jump host [user@]host[:port]; repeatable")
+	fs.Var(&options.jumps, "J", "jump host [user@]host[:port]; repeatable")
+}
+
+// registerPasswordFlags adds the password sources of run and doctor.
+func registerPasswordFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.BoolVar(&options.askPass, "ask-pass", false, "prompt once for the password")
+	fs.StringVar(&options.passwordFile, "password-file", "", "read the password from a file")
+	fs.StringVar(&options.passwordEnv, "password-env", "", "read the password from an environment variable")
+	fs.StringVar(&options.identityPassphraseFile, "identity-passphrase-file", "", "decrypt identity files with the passphrase in a file")
+}
+
+// registerCertificateFlags adds user certificates to run and doctor.
+func registerCertificateFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.Var(&options.certificates, "certificate", "user certificate")
+}
+
+func validatePasswordOptions(options runOptions) error {
+	sources := 0
+	for _, set := range []bool{options.askPass, options.passwordFile != "", options.passwordEnv != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("--ask-pass, --password-file and --password-env are mutually exclusive")
+	}
+	return nil
+}
+
+// passwordSource describes where the password comes from without revealing
+// it, or returns "" when no password is configured.
+func passwordSource(options runOptions) string {
+	switch {
+	case options.askPass:
+		return "prompt"
+	case options.passwordFile != "":
+		return "file:" + options.passwordFile
+	case options.passwordEnv != "":
+		return "env:" + options.passwordEnv
+	}
+	return ""
+}
+
+// loadSecrets reads the password and the identity passphrase once so that
+// every target reuses them. When interactive, the engine may also use the
+// terminal: for --ask-pass, for encrypted identity files and for
+// keyboard-interactive questions. The returned function releases it.
+func loadSecrets(options *runOptions, interactive bool) (func(), *commandError) {
+	closePrompt := func() {}
+	if interactive {
+		if options.prompt == nil {
+			prompter := &ttyPrompter{}
+			options.prompt, closePrompt = prompter.Prompt, prompter.Close
+		}
+		options.config.Prompt = options.prompt
+	}
+	if options.identityPassphraseFile != "" {
+		passphrase, err := readSecretFile(options.identityPassphraseFile)
+		if err != nil {
+			closePrompt()
+			return func() {}, &commandError{
+				Code: "identity_passphrase_unavailable", Message: "--identity-passphrase-file: " + err.Error(),
+				Details: map[string]any{"path": options.identityPassphraseFile},
+			}
+		}
+		options.config.IdentityPassphrase = pssh.Secret(passphrase)
+	}
+	if err := loadPassword(options, interactive); err != nil {
+		closePrompt()
+		return func() {}, err
+	}
+	return closePrompt, nil
+}
+
+// loadPassword reads the password of --password-file, --password-env or,
+// when interactive, --ask-pass.
+func loadPassword(options *runOptions, interactive bool) *commandError {
+	var password string
+	switch {
+	case options.passwordFile != "":
+		value, err := readSecretFile(options.passwordFile)
+		if err != nil {
+			return passwordError(options, err)
+		}
+		password = value
+	case options.passwordEnv != "":
+		value, ok := os.LookupEnv(options.passwordEnv)
+		if !ok {
+			return passwordError(options, fmt.Errorf("%s is not set", options.passwordEnv))
+		}
+		password = value
+	case options.askPass && interactive:
+		value, err := options.prompt("", "Password: ", false)
+		if err != nil {
+			return passwordError(options, err)
+		}
+		password = value
+	default:
+		return nil
+	}
+	if password == "" {
+		return passwordError(options, errors.New("the password is empty"))
+	}
+	options.config.Password = pssh.Secret(password)
+	return nil
+}
+
+func passwordError(options *runOptions, err error) *commandError {
+	return &commandError{
+		Code: "password_unavailable", Message: "password: " + err.Error(),
+		Details: map[string]any{"source": passwordSource(*options)},
+	}
+}
+
+// readSecretFile reads a secret without its trailing newline.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// ttyPrompter asks questions on the controlling terminal, one at a time, so
+// that prompts from concurrent targets do not interleave. The terminal is
+// opened on the first question.
+type ttyPrompter struct {
+	mu sync.Mutex
+	// open replaces openTTY in tests.
+	open func() (terminal, error)
+	// ttyMu guards tty and closed apart from mu, so that Close can end a
+	// prompt nobody waits for any more, such as an abandoned canary check.
+	ttyMu  sync.Mutex
+	tty    terminal
+	closed bool
+}
+
+// terminal is the part of *os.File a ttyPrompter uses.
+type terminal interface {
+	io.ReadWriteCloser
+	Fd() uintptr
+}
+
+func openTTY() (terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt on: %w", err)
+	}
+	return tty, nil
+}
+
+// Prompt implements pssh.PromptFunc. Answers that must not echo are read
+// with the terminal in no-echo mode.
+func (p *ttyPrompter) Prompt(target, question string, echo bool) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tty, err := p.openOnce()
+	if err != nil {
+		return "", err
+	}
+	if target != "" {
+		question = "[" + target + "] " + question
+	}
+	if _, err := fmt.Fprint(tty, question); err != nil {
+		return "", err
+	}
+	if echo {
+		return readTTYLine(tty)
+	}
+	answer, err := term.ReadPassword(int(tty.Fd()))
+	_, _ = fmt.Fprintln(tty)
+	return string(answer), err
+}
+
+// openOnce opens the terminal on first use.
+func (p *ttyPrompter) openOnce() (terminal, error) {
+	p.ttyMu.Lock()
+	defer p.ttyMu.Unlock()
+	if p.closed {
+		return nil, errors.New("terminal prompt is closed")
+	}
+	if p.tty == nil {
+		open := p.open
+		if open == nil {
+			open = openTTY
+		}
+		tty, err := open()
+		if err != nil {
+			return nil, err
+		}
+		p.tty = tty
+	}
+	return p.tty, nil
+}
+
+// Close closes the terminal without waiting for a pending prompt, whose
+// read then fails.
+func (p *ttyPrompter) Close() {
+	p.ttyMu.Lock()
+	defer p.ttyMu.Unlock()
+	p.closed = true
+	if p.tty != nil {
+		_ = p.tty.Close()
+	}
+}
+
+// readTTYLine reads one line byte by byte so that nothing past it is
+// buffered away from later prompts.
+func readTTYLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// registerHostKeyFlags adds host-key verification to run and doctor.
+func registerHostKeyFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.Var((*stringList)(&options.config.HostCAFiles), "host-ca-file", "trusted host CA keys")
+	fs.Var((*stringList)(&options.config.KnownHostsFiles), "known-hosts", "known_hosts file")
+	fs.StringVar(&options.config.HostKeyPolicy, "host-key-policy", "", "strict, accept-new, or insecure")
+}
+
+// applyHostKeyPolicy validates --host-key-policy and folds
+// --insecure-ignore-host-key into it.
+func applyHostKeyPolicy(options *runOptions) error {
+	policy := options.config.HostKeyPolicy
+	switch policy {
+	case "", pssh.HostKeyPolicyStrict, pssh.HostKeyPolicyAcceptNew, pssh.HostKeyPolicyInsecure:
+	default:
+		return fmt.Errorf("--host-key-policy must be strict, accept-new, or insecure")
+	}
+	if options.config.IgnoreHostKey && policy != "" && policy != pssh.HostKeyPolicyInsecure {
+		return fmt.Errorf("--insecure-ignore-host-key conflicts with --host-key-policy %s", policy)
+	}
+	options.config.HostKeyPolicy = options.config.EffectiveHostKeyPolicy()
+	options.config.IgnoreHostKey = options.config.HostKeyPolicy == pssh.HostKeyPolicyInsecure
+	return nil
+}
+
+func registerProxyFlags(fs *flag.FlagSet, options *runOptions) {
+	fs.StringVar(&options.config.ProxyCommand, "proxy-command", "", "connect through a command's stdin and stdout")
+	fs.StringVar(&options.config.Proxy, "proxy", "", "SOCKS5 or HTTP CONNECT proxy URL")
+}
+
+// applyProxyEnvironment uses ALL_PROXY, except for the targets in NO_PROXY,
+// when --proxy is not given. An ALL_PROXY gopssh cannot use is ignored with
+// a warning rather than failing runs that never asked for a proxy.
+func applyProxyEnvironment(fs *flag.FlagSet, options *runOptions, stderr io.Writer) {
+	if flagWasSet(fs, "proxy") {
+		return
+	}
+	proxy := environmentValue("ALL_PROXY")
+	if proxy == "" {
+		return
+	}
+	if _, err := pssh.ParseProxyURL(proxy); err != nil {
+		// nolint: errcheck
+		fmt.Fprintf(stderr, "gopssh: ignoring ALL_PROXY: %s\n", err)
+		return
+	}
+	options.config.Proxy, options.config.NoProxy = proxy, environmentValue("NO_PROXY")
+}
+
+// environmentValue returns the variable name, or its lower-case form when
+// that is unset or empty.
+func environmentValue(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// redactedProxy returns the proxy URL without its password, or "" when no
+// proxy applies.
+func redactedProxy(options runOptions) string {
+	if options.config.Proxy == "" || options.config.Proxy == pssh.ProxyNone {
+		return ""
+	}
+	proxyURL, err := pssh.ParseProxyURL(options.config.Proxy)
+	if err != nil {
+		return ""
+	}
+	return proxyURL.Redacted()
+}
+
+func flagWasSet(fs *flag.FlagSet, names ...string) bool {
+	set := false
+	fs.Visit(func(parsed *flag.Flag) {
+		set = set || contains(names, parsed.Name)
+	})
+	return set
+}
+
+// sshConfigPath returns the ssh_config file to read, or "" when none applies.
+// A missing default file is not an error; an explicit --ssh-config must exist.
+func sshConfigPath(options runOptions) string {
+	if options.noSSHConfig {
+		return ""
+	}
+	if options.sshConfig != "" {
+		return expandHome(options.sshConfig)
+	}
+	path := pssh.DefaultSSHConfigPath()
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// resolveTargets applies inventory variables and ssh_config HostName, Port,
+// User, IdentityFile, IdentitiesOnly, ProxyCommand and ProxyJump to each valid
+// entry. A port written in the target or inventory wins over Port, inventory
+// variables win over the file, and explicit --user, --jump and --proxy-command
+// options win over both, as on the ssh command line. A user written in the
+// target as user@host wins over everything, like a port written there.
+// ProxyCommand takes precedence over ProxyJump from the same source.
+func resolveTargets(entries []hostEntry, options runOptions) error {
+	var config *pssh.SSHConfig
+	if path := sshConfigPath(options); path != "" {
+		var err error
+		if config, err = pssh.ReadSSHConfig(path); err != nil {
+			return err
+		}
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Error != "" {
+			continue
+		}
+		resolved, err := config.Lookup(entry.Host)
+		if err != nil {
+			return err
+		}
+		host, port := entry.Host, entry.Port
+		if resolved.HostName != "" {
+			host = resolved.HostName
+		}
+		if _, _, err := net.SplitHostPort(entry.Original); err != nil && resolved.Port != 0 {
+			port = resolved.Port
+		}
+		if address := net.JoinHostPort(host, strconv.Itoa(port)); address != entry.Normalized {
+			entry.Address = address
+		}
+		if entry.targetUser != "" {
+			entry.User = entry.targetUser
+		} else if !options.userSet {
+			entry.User = valueOr(entry.inventory.User, resolved.User)
+		}
+		entry.IdentityFiles = slices.Concat(entry.inventory.IdentityFiles, resolved.IdentityFiles)
+		entry.IdentitiesOnly = resolved.IdentitiesOnly
+		proxyCommand, jumps := resolved.ProxyCommand, []string{resolved.ProxyJump}
+		if entry.inventory.ProxyCommand != "" || entry.inventory.Jump != "" {
+			proxyCommand, jumps = entry.inventory.ProxyCommand, []string{entry.inventory.Jump}
+		}
+		if options.config.ProxyCommand != "" || len(options.jumps) > 0 {
+			proxyCommand, jumps = options.config.ProxyCommand, options.jumps
+		}
+		entry.ProxyCommand = proxyCommand
+		if strings.EqualFold(entry.ProxyCommand, pssh.ProxyCommandNone) {
+			entry.ProxyCommand = ""
+		}
+		if entry.ProxyCommand != "" {
+			continue
+		}
+		if entry.jumps, err = resolveJumpHosts(jumps, config); err != nil {
+			return fmt.Errorf("%s: %w", entry.Original, err)
+		}
+		for _, hop := range entry.jumps {
+			entry.JumpHosts = append(entry.JumpHosts, hop.String())
+		}
+	}
+	return nil
+}
+
+// resolveJumpHosts parses ProxyJump chains and resolves each hop through
+// ssh_config. "none" yields an empty non-nil chain, which connects directly.
+func resolveJumpHosts(specs []string, config *pssh.SSHConfig) ([]pssh.JumpHost, error) {
+	var hops []pssh.JumpHost
+	for _, value := range specs {
+		if strings.EqualFold(value, "none") {
+			return []pssh.JumpHost{}, nil
+		}
+		for _, spec := range pssh.ToSlice(value) {
+			hop, err := pssh.ParseJumpHost(spec)
+			if err != nil {
+				return nil, err
+			}
+			host, port, _ := net.SplitHostPort(hop.Addr)
+			resolved, err := config.Lookup(host)
+			if err != nil {
+				return nil, err
+			}
+			if resolved.HostName != "" {
+				host = resolved.HostName
+			}
+			hostPart := spec[strings.LastIndex(spec, "@")+1:]
+			if _, _, err := net.SplitHostPort(hostPart); err != nil && resolved.Port != 0 {
+				port = strconv.Itoa(resolved.Port)
+			}
+			hop.Addr = net.JoinHostPort(host, port)
+			hop.User = valueOr(hop.User, resolved.User)
+			hop.IdentFiles, hop.IdentitiesOnly = resolved.IdentityFiles, resolved.IdentitiesOnly
+			hops = append(hops, hop)
+		}
+	}
+	return hops, nil
 }
 
 var dnsLabelPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
@@ -496,6 +1392,152 @@ func readStdin(options runOptions, stdin io.Reader) ([]byte, error) {
 	return data, nil
 }
 
+// applyBatchOptions parses --batch-size and --max-fail into the config.
+func applyBatchOptions(options *runOptions) error {
+	if options.batchSize != "" {
+		size, err := pssh.ParseThreshold(options.batchSize)
+		if err != nil || size.Value == 0 {
+			return fmt.Errorf("--batch-size must be a positive number or percentage")
+		}
+		options.config.BatchSize = size
+	}
+	if options.config.BatchPause < 0 || options.config.BatchPause > 0 && options.batchSize == "" {
+		return fmt.Errorf("--batch-pause must not be negative and requires --batch-size")
+	}
+	if options.maxFail != "" {
+		maxFail, err := pssh.ParseThreshold(options.maxFail)
+		if err != nil {
+			return fmt.Errorf("--max-fail: %w", err)
+		}
+		options.config.MaxFail = &maxFail
+	}
+	return nil
+}
+
+func validateCanaryOptions(options runOptions) error {
+	switch {
+	case options.canary < 0:
+		return fmt.Errorf("--canary must not be negative")
+	case options.canary > 0 && len(options.canaryHosts) > 0:
+		return fmt.Errorf("--canary and --canary-host are mutually exclusive")
+	case options.yes && options.canary == 0 && len(options.canaryHosts) == 0:
+		return fmt.Errorf("--yes requires --canary or --canary-host")
+	}
+	for _, value := range options.canaryHosts {
+		if _, err := pssh.ParseHostExclusions(value, ""); err != nil {
+			return fmt.Errorf("--canary-host: %w", err)
+		}
+	}
+	return nil
+}
+
+// canaryTargets returns the indices of the first --canary targets, or of
+// the targets matched by --canary-host in input order. At least one other
+// target must remain.
+func canaryTargets(options runOptions, entries []hostEntry) ([]int, error) {
+	var canaries []int
+	for i := range min(options.canary, len(entries)) {
+		canaries = append(canaries, i)
+	}
+	for _, value := range options.canaryHosts {
+		patterns, err := pssh.ParseHostExclusions(value, "--canary-host "+value)
+		if err != nil {
+			return nil, fmt.Errorf("--canary-host: %w", err)
+		}
+		matched := false
+		for i, entry := range entries {
+			if _, ok := pssh.MatchHostExclusion(patterns, entry.Normalized); ok {
+				matched = true
+				if !slices.Contains(canaries, i) {
+					canaries = append(canaries, i)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("--canary-host %s matches no target", value)
+		}
+	}
+	if len(canaries) > 0 && len(canaries) >= len(entries) {
+		return nil, fmt.Errorf("the canaries would be all %d targets; at least one other target is required", len(entries))
+	}
+	slices.Sort(canaries)
+	return canaries, nil
+}
+
+// confirmCanaries decides whether the targets after passing canaries start.
+// On a terminal it asks unless --yes is given; otherwise it continues.
+func confirmCanaries(options runOptions, stderr io.Writer, canary pssh.CanaryStats, remaining int) bool {
+	if options.yes || !options.canaryPrompt {
+		_, _ = fmt.Fprintf(stderr, "Canaries passed on %d targets; continuing with the remaining %d\n", canary.Targets, remaining)
+		return true
+	}
+	question := fmt.Sprintf("Canaries passed on %d targets. Continue with the remaining %d? [y/N] ", canary.Targets, remaining)
+	answer, err := options.prompt("", question, true)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error: %s\n", err)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// loadSteps reads --script or collects --step into config.Steps. Steps
+// replace the command, so they conflict with --command and arguments after
+// --; --stop-on-failure needs them.
+func loadSteps(options *runOptions, hasArgs bool) *usageError {
+	usage := func(code, message, token string) *usageError {
+		return newUsageError(code, message, []string{"gopssh", "run"}, token, nil, runUsage())
+	}
+	switch {
+	case options.script != "" && len(options.steps) > 0:
+		return usage("conflicting_options", "--script and --step are mutually exclusive", "--step")
+	case options.script == "" && len(options.steps) == 0:
+		if options.config.StopOnFailure {
+			return usage("invalid_argument", "--stop-on-failure requires --script or --step", "--stop-on-failure")
+		}
+		return nil
+	case options.command != "" || hasArgs:
+		return usage("conflicting_options", "--script and --step replace --command and command arguments after --", "--command")
+	}
+	steps := []string(options.steps)
+	if options.script != "" {
+		var err error
+		if steps, err = readScript(options.script); err != nil {
+			return usage("invalid_argument", "--script: "+err.Error(), options.script)
+		}
+	}
+	for i, step := range steps {
+		if strings.TrimSpace(step) == "" {
+			return usage("invalid_argument", fmt.Sprintf("step %d is empty", i+1), "--step")
+		}
+	}
+	options.config.Steps = steps
+	return nil
+}
+
+// readScript returns the commands of a script file: one shell command per
+// line, skipping blank lines and lines starting with #.
+func readScript(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var steps []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			steps = append(steps, line)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%s has no commands", path)
+	}
+	return steps, nil
+}
+
 func shellJoin(args []string) string {
 	quoted := make([]string, len(args))
 	for i, arg := range args {
@@ -504,85 +1546,350 @@ func shellJoin(args []string) string {
 	return strings.Join(quoted, " ")
 }
 
-func printDryRun(options runOptions, targets []string, stdout io.Writer) int {
+// credentialGroup lists the targets that share a user and identity files.
+type credentialGroup struct {
+	User          string   `json:"user"`
+	IdentityFiles []string `json:"identity_files,omitempty"`
+	Targets       []string `json:"targets"`
+}
+
+// credentialGroups groups hosts by user and identity files in order of first
+// appearance.
+func credentialGroups(hosts []dryRunHost) []credentialGroup {
+	var groups []credentialGroup
+	index := map[string]int{}
+	for _, host := range hosts {
+		key := host.User + "\x00" + strings.Join(host.IdentityFiles, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, credentialGroup{User: host.User, IdentityFiles: host.IdentityFiles})
+		}
+		groups[i].Targets = append(groups[i].Targets, host.Target)
+	}
+	return groups
+}
+
+type dryRunHost struct {
+	Target         string            `json:"target"`
+	Address        string            `json:"address"`
+	User           string            `json:"user"`
+	IdentityFiles  []string          `json:"identity_files,omitempty"`
+	IdentitiesOnly bool              `json:"identities_only"`
+	JumpHosts      []string          `json:"jump_hosts,omitempty"`
+	ProxyCommand   string            `json:"proxy_command,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+func dryRunHosts(options runOptions, entries []hostEntry) []dryRunHost {
+	hosts := make([]dryRunHost, len(entries))
+	for i, entry := range entries {
+		hosts[i] = dryRunHost{
+			Target:         entry.Normalized,
+			Address:        valueOr(entry.Address, entry.Normalized),
+			User:           valueOr(entry.User, options.config.User),
+			IdentityFiles:  entry.IdentityFiles,
+			IdentitiesOnly: options.config.IdentityFileOnly || entry.IdentitiesOnly,
+			JumpHosts:      entry.JumpHosts,
+			ProxyCommand:   entry.ProxyCommand,
+			Labels:         entry.Labels,
+		}
+	}
+	return hosts
+}
+
+func printDryRun(options runOptions, entries []hostEntry, stdout io.Writer) int {
+	targets := entryTargets(entries)
+	hosts := dryRunHosts(options, entries)
 	auth := []string{"identity-files"}
 	if !options.config.IdentityFileOnly && options.config.SSHAuthSocket != "" {
 		auth = append([]string{"ssh-agent"}, auth...)
 	}
+	if passwordSource(options) != "" {
+		auth = append(auth, "keyboard-interactive", "password")
+	}
+	credentials := credentialGroups(hosts)
+	canaryNames := make([]string, len(options.config.Canaries))
+	for i, index := range options.config.Canaries {
+		canaryNames[i] = targets[index]
+	}
+	warnings := []string{}
+	if options.config.ForwardAgent {
+		warnings = append(warnings, "agent forwarding is enabled: anyone with root on a target can use your agent keys while the command runs")
+	}
 	plan := map[string]any{
 		"schema_version":        schemaVersion,
 		"type":                  "dry_run",
 		"targets":               targets,
+		"hosts":                 hosts,
+		"credential_groups":     credentials,
+		"ssh_config":            sshConfigPath(options),
+		"groups":                options.groups,
+		"select":                options.selects,
+		"excluded":              options.excluded,
+		"proxy":                 redactedProxy(options),
+		"no_proxy":              options.config.NoProxy,
+		"hosts_exec":            options.hostsExecRun,
 		"user":                  options.config.User,
 		"parallel":              options.config.Concurrency,
 		"max_agent_connections": options.config.MaxAgentConns,
 		"authentication":        auth,
-		"host_key_policy":       map[bool]string{true: "insecure-ignore", false: "known-hosts"}[options.config.IgnoreHostKey],
+		"forward_agent":         options.config.ForwardAgent,
+		"certificates":          options.certificates,
+		"password_source":       passwordSource(options),
+		"host_key_policy":       options.config.EffectiveHostKeyPolicy(),
+		"known_hosts_files":     options.config.EffectiveKnownHostsFiles(),
+		"host_ca_files":         options.config.HostCAFiles,
 		"connect_timeout":       options.config.Timeout.String(),
+		"command_timeout":       options.config.CommandTimeout.String(),
+		"deadline":              options.config.Deadline.String(),
+		"grace_period":          options.config.GracePeriod.String(),
 		"order":                 options.order,
 		"color":                 options.color,
 		"max_buffer_memory":     options.config.MaxBufferMemory,
 		"max_spool_size":        options.config.MaxSpoolSize,
 		"spool_dir":             options.config.SpoolDir,
 		"command":               options.command,
+		"steps":                 options.config.Steps,
+		"stop_on_failure":       options.config.StopOnFailure,
+		"batch_size":            options.batchSize,
+		"batches":               batchCount(options.config.BatchSize, len(targets)),
+		"batch_pause":           options.config.BatchPause.String(),
+		"max_fail":              options.maxFail,
+		"canaries":              canaryNames,
 		"stdin_bytes":           len(options.config.Stdin),
 		"output_dir":            options.outputDir,
 		"exit_policy":           options.exitPolicy,
+		"warnings":              warnings,
+	}
+	if options.json {
+		if err := json.NewEncoder(stdout).Encode(plan); err != nil {
+			return 1
+		}
+		return 0
+	}
+	if _, err := fmt.Fprintf(stdout, "Dry run (no network connection)\n"); err != nil {
+		return 1
+	}
+	for _, warning := range warnings {
+		if _, err := fmt.Fprintf(stdout, "WARNING: %s\n", warning); err != nil {
+			return 1
+		}
+	}
+	if _, err := fmt.Fprintf(stdout, "Targets: %d\n", len(targets)); err != nil {
+		return 1
+	}
+	for _, host := range hosts {
+		line := "  " + host.Target
+		if host.Address != host.Target || host.User != options.config.User || len(host.IdentityFiles) > 0 {
+			line += fmt.Sprintf(" -> %s user=%s", host.Address, host.User)
+			if len(host.IdentityFiles) > 0 {
+				line += " identity=" + strings.Join(host.IdentityFiles, ",")
+			}
+		}
+		if len(host.JumpHosts) > 0 {
+			line += " via " + strings.Join(host.JumpHosts, ",")
+		}
+		if host.ProxyCommand != "" {
+			line += " proxy-command=" + strconv.Quote(host.ProxyCommand)
+		}
+		if len(options.selects) > 0 && len(host.Labels) > 0 {
+			line += " labels=" + labelString(host.Labels)
+		}
+		if _, err := fmt.Fprintln(stdout, line); err != nil {
+			return 1
+		}
+	}
+	if len(credentials) > 1 {
+		if _, err := fmt.Fprintf(stdout, "Credentials: %d groups\n", len(credentials)); err != nil {
+			return 1
+		}
+		for _, group := range credentials {
+			line := "  user=" + group.User
+			if len(group.IdentityFiles) > 0 {
+				line += " identity=" + strings.Join(group.IdentityFiles, ",")
+			}
+			if _, err := fmt.Fprintf(stdout, "%s: %s\n", line, strings.Join(group.Targets, ", ")); err != nil {
+				return 1
+			}
+		}
+	}
+	if run := options.hostsExecRun; run != nil {
+		if _, err := fmt.Fprintf(stdout, "Hosts exec: %s (exit %d, %d targets)\n", run.Command, run.ExitCode, run.Targets); err != nil {
+			return 1
+		}
+	}
+	if len(options.excluded) > 0 {
+		if _, err := fmt.Fprintf(stdout, "Excluded: %d\n", len(options.excluded)); err != nil {
+			return 1
+		}
+		for _, exclusion := range options.excluded {
+			if _, err := fmt.Fprintf(stdout, "  %s (%s)\n", exclusion.Target, exclusion.Reason); err != nil {
+				return 1
+			}
+		}
+	}
+	if _, err := fmt.Fprintf(stdout, "User: %s\nParallel: %d\nAuthentication: %s\nHost key policy: %s\n",
+		options.config.User, options.config.Concurrency, strings.Join(auth, ", "), plan["host_key_policy"]); err != nil {
+		return 1
+	}
+	if source := passwordSource(options); source != "" {
+		if _, err := fmt.Fprintf(stdout, "Password: %s\n", source); err != nil {
+			return 1
+		}
+	}
+	if proxy := redactedProxy(options); proxy != "" {
+		if _, err := fmt.Fprintf(stdout, "Proxy: %s\n", proxy); err != nil {
+			return 1
+		}
+		if options.config.NoProxy != "" {
+			if _, err := fmt.Fprintf(stdout, "No proxy: %s\n", options.config.NoProxy); err != nil {
+				return 1
+			}
+		}
 	}
-	if options.json {
-		if err := json.NewEncoder(stdout).Encode(plan); err != nil {
+	if options.batchSize != "" {
+		if _, err := fmt.Fprintf(stdout, "Batches: %d of %d targets, %s apart\n", batchCount(options.config.BatchSize, len(targets)),
+			max(1, options.config.BatchSize.Of(len(targets))), options.config.BatchPause); err != nil {
 			return 1
 		}
-		return 0
 	}
-	if _, err := fmt.Fprintf(stdout, "Dry run (no network connection)\nTargets: %d\n", len(targets)); err != nil {
-		return 1
+	if options.maxFail != "" {
+		if _, err := fmt.Fprintf(stdout, "Max fail: %s\n", options.maxFail); err != nil {
+			return 1
+		}
 	}
-	for _, target := range targets {
-		if _, err := fmt.Fprintf(stdout, "  %s\n", target); err != nil {
+	if timeouts := commandTimeouts(options.config); timeouts != "" {
+		if _, err := fmt.Fprintf(stdout, "Timeouts: %s\n", timeouts); err != nil {
 			return 1
 		}
 	}
-	if _, err := fmt.Fprintf(stdout, "User: %s\nParallel: %d\nAuthentication: %s\nHost key policy: %s\n",
-		options.config.User, options.config.Concurrency, strings.Join(auth, ", "), plan["host_key_policy"]); err != nil {
-		return 1
+	if len(canaryNames) > 0 {
+		if _, err := fmt.Fprintf(stdout, "Canaries: %s\n", strings.Join(canaryNames, ", ")); err != nil {
+			return 1
+		}
+	}
+	commandLine := "Command: " + options.command + "\n"
+	if steps := options.config.Steps; len(steps) > 0 {
+		commandLine = fmt.Sprintf("Steps: %d", len(steps))
+		if options.config.StopOnFailure {
+			commandLine += " (stop on failure)"
+		}
+		commandLine += "\n"
+		for i, step := range steps {
+			commandLine += fmt.Sprintf("  %d. %s\n", i+1, step)
+		}
 	}
-	if _, err := fmt.Fprintf(stdout, "Command: %s\nOrder: %s\nColor: %s\nExit policy: %s\n",
-		options.command, options.order, options.color, options.exitPolicy); err != nil {
+	if _, err := fmt.Fprintf(stdout, "%sOrder: %s\nColor: %s\nExit policy: %s\n",
+		commandLine, options.order, options.color, options.exitPolicy); err != nil {
 		return 1
 	}
 	return 0
 }
 
+// commandTimeouts describes --command-timeout and --deadline, or is empty
+// without them.
+func commandTimeouts(config pssh.Config) string {
+	var timeouts []string
+	if config.CommandTimeout > 0 {
+		timeouts = append(timeouts, fmt.Sprintf("%s per command", config.CommandTimeout))
+	}
+	if config.Deadline > 0 {
+		timeouts = append(timeouts, fmt.Sprintf("%s for the run", config.Deadline))
+	}
+	if len(timeouts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s, then SIGTERM and %s grace", strings.Join(timeouts, ", "), config.GracePeriod)
+}
+
+// batchCount is the number of batches of total targets.
+func batchCount(size pssh.Threshold, total int) int {
+	if size.Value == 0 || total == 0 {
+		return min(1, total)
+	}
+	per := max(1, size.Of(total))
+	return (total + per - 1) / per
+}
+
 type runStats struct {
-	total, succeeded, failed, connectionFailed, canceled, localErrors int
+	total, succeeded, failed, connectionFailed, canceled, localErrors, excluded int
+	// timedOut counts the timeout results, also counted in failed.
+	timedOut int
+	// steps is the number of --script or --step commands; total then counts
+	// one result per target and step, and skipped the steps not run.
+	steps, skipped int
+	// The connection failures with a known cause, also counted in
+	// connectionFailed.
+	hostKeyMismatch, hostKeyUnknown, authFailed, dnsFailed, connectTimeout, connectionRefused int
+	// agent counts the SSH Agent requests of the engine.
+	agent pssh.AgentStats
+	// batches are reported with --batch-size or --max-fail.
+	batched         bool
+	batches         []pssh.BatchStats
+	maxFailExceeded bool
+	// canary is reported with --canary or --canary-host.
+	canary *pssh.CanaryStats
+	// signal is the signal forwarded to the remote commands when a local one
+	// canceled the run.
+	signal      *pssh.SignalCause
+	signalStats pssh.SignalStats
 }
 
 func executeRun(ctx context.Context, options runOptions, targets []string, stdout, stderr io.Writer) int {
-	stats := &runStats{total: len(targets)}
-	seen := make(map[int]bool, len(targets))
+	steps := len(options.config.Steps)
+	stats := &runStats{
+		total: len(targets) * max(1, steps), excluded: len(options.excluded), steps: steps,
+		batched: options.batchSize != "" || options.maxFail != "",
+	}
+	seen := make(map[int]bool, stats.total)
 	handler := func(result *pssh.Result) error {
-		seen[result.Index] = true
+		seen[result.Index*max(1, steps)+result.Step] = true
 		return handleRunResult(options, stats, stdout, stderr, result)
 	}
 	if options.json || options.outputDir != "" {
 		options.config.ResultHandler = handler
 	}
+	if canaries := len(options.config.Canaries); canaries > 0 {
+		options.config.CanaryCheck = func(canary pssh.CanaryStats) bool {
+			return confirmCanaries(options, stderr, canary, len(targets)-canaries)
+		}
+	}
 	engine := &pssh.Pssh{Config: &options.config}
 	if err := engine.Validate(); err != nil {
 		return 2
 	}
 	engine.Init()
 	code := engine.RunContext(ctx)
+	stats.agent = engine.AgentStats()
+	stats.batches, stats.maxFailExceeded = engine.Batches(), engine.MaxFailExceeded()
+	if errors.As(context.Cause(ctx), &stats.signal) {
+		stats.signalStats = engine.SignalStats()
+		_, _ = fmt.Fprintf(stderr, "Signals: sent SIG%s to %d remote commands; %d exited before their sessions closed\n",
+			stats.signal.Signal, stats.signalStats.Sent, stats.signalStats.Exited)
+	}
+	if canaries := len(options.config.Canaries); canaries > 0 {
+		canary := engine.Canary()
+		stats.canary = &canary
+		if !canary.Continued && ctx.Err() == nil {
+			reason := fmt.Sprintf("%d of %d canaries failed", canary.Failed, canaries)
+			if canary.Passed {
+				reason = "not confirmed"
+			}
+			_, _ = fmt.Fprintf(stderr, "Canaries: %s; %d targets not started\n", reason, len(targets)-canaries)
+		}
+	}
 	if options.json {
 		if ctx.Err() != nil {
-			for index, target := range targets {
-				if seen[index] {
+			for i := range stats.total {
+				if seen[i] {
 					continue
 				}
+				index := i / max(1, steps)
 				canceledResult := &pssh.Result{
-					Index: index, Target: target, Kind: pssh.ResultCanceled, ExitCode: 1, Err: context.Canceled,
-					Stdout: emptyResultOutput{}, Stderr: emptyResultOutput{},
+					Index: index, Target: targets[index], Kind: pssh.ResultCanceled, ExitCode: 1, Err: context.Canceled,
+					Stdout: emptyResultOutput{}, Stderr: emptyResultOutput{}, Step: i % max(1, steps), Steps: steps,
 				}
 				if err := handleRunResult(options, stats, stdout, stderr, canceledResult); err != nil && options.outputDir == "" {
 					return 1
@@ -631,27 +1938,87 @@ func handleRunResult(
 }
 
 func writeJSONSummary(writer io.Writer, stats *runStats, code int) error {
-	return json.NewEncoder(writer).Encode(map[string]any{
+	agentStats := map[string]any{
+		"lists": stats.agent.Lists, "signs": stats.agent.Signs, "errors": stats.agent.Errors,
+		"wait_ms": stats.agent.Wait.Milliseconds(),
+	}
+	summary := map[string]any{
 		"schema_version":      schemaVersion,
 		"type":                "summary",
 		"total":               stats.total,
 		"succeeded":           stats.succeeded,
 		"failed":              stats.failed,
 		"connection_failed":   stats.connectionFailed,
+		"host_key_mismatch":   stats.hostKeyMismatch,
+		"host_key_unknown":    stats.hostKeyUnknown,
+		"auth_failed":         stats.authFailed,
+		"dns_failed":          stats.dnsFailed,
+		"connect_timeout":     stats.connectTimeout,
+		"connection_refused":  stats.connectionRefused,
 		"canceled":            stats.canceled,
+		"timeout":             stats.timedOut,
 		"local_errors":        stats.localErrors,
+		"excluded":            stats.excluded,
+		"agent":               agentStats,
 		"aggregate_exit_code": code,
-	})
+	}
+	if stats.steps > 0 {
+		summary["steps"] = stats.steps
+		summary["skipped"] = stats.skipped
+	}
+	if stats.batched {
+		batches := make([]map[string]any, len(stats.batches))
+		for i, batch := range stats.batches {
+			batches[i] = map[string]any{
+				"batch": i + 1, "targets": batch.Targets, "failed": batch.Failed,
+				"duration_ms": batch.Duration.Milliseconds(),
+			}
+		}
+		summary["batches"] = batches
+		summary["max_fail_exceeded"] = stats.maxFailExceeded
+	}
+	if stats.signal != nil {
+		summary["signal"] = map[string]any{
+			"name": "SIG" + string(stats.signal.Signal), "sent": stats.signalStats.Sent, "exited": stats.signalStats.Exited,
+		}
+	}
+	if canary := stats.canary; canary != nil {
+		summary["canary"] = map[string]any{
+			"targets": canary.Targets, "failed": canary.Failed, "passed": canary.Passed,
+			"continued": canary.Continued, "duration_ms": canary.Duration.Milliseconds(),
+		}
+	}
+	return json.NewEncoder(writer).Encode(summary)
 }
 
 func updateRunStats(stats *runStats, result *pssh.Result) {
-	switch resultStatus(result) {
-	case string(pssh.ResultCanceled):
+	status := resultStatus(result)
+	switch status {
+	case string(pssh.ResultHostKeyMismatch):
+		stats.hostKeyMismatch++
+	case string(pssh.ResultHostKeyUnknown):
+		stats.hostKeyUnknown++
+	case string(pssh.ResultAuthFailed):
+		stats.authFailed++
+	case string(pssh.ResultDNSFailed):
+		stats.dnsFailed++
+	case string(pssh.ResultConnectTimeout):
+		stats.connectTimeout++
+	case string(pssh.ResultConnectionRefused):
+		stats.connectionRefused++
+	}
+	switch {
+	case status == string(pssh.ResultCanceled):
 		stats.canceled++
-	case string(pssh.ResultConnectionFailed):
+	case status == string(pssh.ResultSkipped):
+		stats.skipped++
+	case status == string(pssh.ResultTimeout):
+		stats.timedOut++
+		stats.failed++
+	case pssh.ResultKind(status).ConnectFailed():
 		stats.connectionFailed++
 		stats.failed++
-	case "success":
+	case status == "success":
 		stats.succeeded++
 	default:
 		stats.failed++
@@ -682,6 +2049,9 @@ func writeOutputFiles(directory string, result *pssh.Result) (string, string, er
 		return "", "", err
 	}
 	base := fmt.Sprintf("%d-%s", result.Index, sanitizeTarget(result.Target))
+	if result.Steps > 0 {
+		base += fmt.Sprintf(".step%d", result.Step)
+	}
 	stdoutPath := filepath.Join(absolute, base+".stdout")
 	stderrPath := filepath.Join(absolute, base+".stderr")
 	if err := writeResultFile(stdoutPath, result.Stdout); err != nil {
@@ -757,6 +2127,17 @@ func writeJSONResult(writer io.Writer, result *pssh.Result, outputDir string) er
 		"target": result.Target, "status": status, "exit_code": result.ExitCode,
 		"error": errorMessage, "duration_ms": result.Duration.Milliseconds(),
 	}
+	if result.Steps > 0 {
+		prefix["step"] = result.Step
+	}
+	if result.Canary {
+		prefix["canary"] = true
+	}
+	var hostKeyErr *pssh.HostKeyError
+	if errors.As(result.Err, &hostKeyErr) {
+		prefix["host_key_type"] = hostKeyErr.Key.Type()
+		prefix["host_key_fingerprint"] = ssh.FingerprintSHA256(hostKeyErr.Key)
+	}
 	if outputDir != "" {
 		stdoutPath, stderrPath, err := writeOutputFiles(outputDir, result)
 		if err != nil {
@@ -789,8 +2170,12 @@ func resultStatus(result *pssh.Result) string {
 	switch {
 	case result.Kind == pssh.ResultCanceled || errors.Is(result.Err, context.Canceled):
 		return string(pssh.ResultCanceled)
-	case result.Kind == pssh.ResultConnectionFailed:
-		return string(pssh.ResultConnectionFailed)
+	case result.Kind == pssh.ResultSkipped:
+		return string(pssh.ResultSkipped)
+	case result.Kind == pssh.ResultTimeout:
+		return string(pssh.ResultTimeout)
+	case result.Kind.ConnectFailed():
+		return string(result.Kind)
 	case result.Kind == pssh.ResultOutputFailed:
 		return string(pssh.ResultOutputFailed)
 	case result.ExitCode != 0 || result.Err != nil:
@@ -950,57 +2335,232 @@ func (w *jsonStringWriter) end() error {
 }
 
 type hostEntry struct {
-	Index      int    `json:"index"`
-	Original   string `json:"original"`
-	Normalized string `json:"normalized,omitempty"`
-	Host       string `json:"host,omitempty"`
-	Port       int    `json:"port,omitempty"`
-	Kind       string `json:"kind,omitempty"`
-	Duplicate  bool   `json:"duplicate"`
-	Line       int    `json:"line"`
-	Error      string `json:"error,omitempty"`
-}
-
-func parseHostEntries(path string) ([]hostEntry, error) {
+	Index                  int               `json:"index"`
+	Original               string            `json:"original"`
+	Normalized             string            `json:"normalized,omitempty"`
+	Host                   string            `json:"host,omitempty"`
+	Port                   int               `json:"port,omitempty"`
+	Kind                   string            `json:"kind,omitempty"`
+	Duplicate              bool              `json:"duplicate"`
+	Line                   int               `json:"line"`
+	Error                  string            `json:"error,omitempty"`
+	Pattern                string            `json:"pattern,omitempty"`
+	Excluded               string            `json:"excluded,omitempty"`
+	Addresses              []string          `json:"addresses,omitempty"`
+	ResolveError           string            `json:"resolve_error,omitempty"`
+	HiddenDuplicateOf      string            `json:"hidden_duplicate_of,omitempty"`
+	Address                string            `json:"address,omitempty"`
+	User                   string            `json:"user,omitempty"`
+	IdentityFiles          []string          `json:"identity_files,omitempty"`
+	IdentitiesOnly         bool              `json:"identities_only,omitempty"`
+	JumpHosts              []string          `json:"jump_hosts,omitempty"`
+	ProxyCommand           string            `json:"proxy_command,omitempty"`
+	Groups                 []string          `json:"groups,omitempty"`
+	Labels                 map[string]string `json:"labels,omitempty"`
+	EffectiveUser          string            `json:"effective_user,omitempty"`
+	EffectiveIdentityFiles []string          `json:"effective_identity_files,omitempty"`
+
+	jumps      []pssh.JumpHost
+	inventory  pssh.InventoryVars
+	targetUser string
+	negated    bool
+}
+
+// newHostEntry parses a [user@]host[:port] target.
+func newHostEntry(index int, value string, line int) hostEntry {
+	entry := hostEntry{Index: index, Original: value, Line: line}
+	user, address, err := pssh.SplitTargetUser(value)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.targetUser = user
+	normalized, normalizeErr := normalizeModernHost(address)
+	if normalizeErr != nil {
+		entry.Error = normalizeErr.Error()
+		return entry
+	}
+	entry.Normalized = normalized
+	host, port, _ := net.SplitHostPort(normalized)
+	entry.Host = host
+	entry.Port, _ = strconv.Atoi(port)
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		entry.Kind = "dns"
+	case strings.Contains(host, ":"):
+		entry.Kind = "ipv6"
+	default:
+		entry.Kind = "ipv4"
+	}
+	return entry
+}
+
+// newHostEntries expands a host pattern into one entry per target, each
+// recording the pattern it came from. A pattern that cannot be expanded yields
+// a single entry carrying the error.
+func newHostEntries(index int, value string, line int) []hostEntry {
+	if !pssh.IsHostPattern(value) {
+		return []hostEntry{newHostEntry(index, value, line)}
+	}
+	values, err := pssh.ExpandHostPattern(value)
+	if err != nil {
+		return []hostEntry{{Index: index, Original: value, Line: line, Error: err.Error()}}
+	}
+	entries := make([]hostEntry, len(values))
+	for i, expanded := range values {
+		entries[i] = newHostEntry(index+i, expanded, line)
+		entries[i].Pattern = value
+	}
+	return entries
+}
+
+// newInventoryEntries expands an inventory or hosts-file host and attaches
+// its groups and variables to each entry.
+func newInventoryEntries(index int, host pssh.InventoryHost) []hostEntry {
+	entries := newHostEntries(index, host.Target(), host.Line)
+	for i := range entries {
+		entries[i].Groups = host.Groups
+		entries[i].Labels = host.Vars.Labels
+		entries[i].inventory = host.Vars
+	}
+	return entries
+}
+
+// parseHostLine reads one line of a plain hosts file, or one --host value:
+// targets followed by key=value options that apply to each of them. A !host
+// target yields negated entries that applyExclusions turns into exclusions.
+func parseHostLine(index int, text string, line int) []hostEntry {
+	targets, vars, err := pssh.ParseHostLine(text)
+	if err != nil {
+		return []hostEntry{{Index: index, Original: strings.TrimSpace(text), Line: line, Error: err.Error()}}
+	}
+	var entries []hostEntry
+	for _, target := range targets {
+		negated, ok := strings.CutPrefix(target, "!")
+		if !ok {
+			entries = append(entries, newInventoryEntries(index+len(entries), pssh.InventoryHost{Name: target, Line: line, Vars: vars})...)
+			continue
+		}
+		for _, entry := range newHostEntries(index+len(entries), negated, line) {
+			entry.negated = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// markDuplicates flags every valid entry whose target appeared earlier.
+func markDuplicates(entries []hostEntry) {
+	seen := map[string]bool{}
+	for i := range entries {
+		if entries[i].Error == "" && !entries[i].negated {
+			entries[i].Duplicate = seen[entries[i].Normalized]
+			seen[entries[i].Normalized] = true
+		}
+	}
+}
+
+// resolvedFields renders the source pattern and the inventory and ssh_config
+// values for the text hosts listing.
+func (e hostEntry) resolvedFields() string {
+	var fields string
+	if e.Pattern != "" {
+		fields += "\tpattern=" + e.Pattern
+	}
+	if e.Excluded != "" {
+		fields += "\texcluded=" + strconv.Quote(e.Excluded)
+	}
+	if e.Address != "" {
+		fields += "\taddress=" + e.Address
+	}
+	if e.User != "" {
+		fields += "\tuser=" + e.User
+	}
+	if len(e.IdentityFiles) > 0 {
+		fields += "\tidentity=" + strings.Join(e.IdentityFiles, ",")
+	}
+	if e.IdentitiesOnly {
+		fields += "\tidentities_only=true"
+	}
+	if len(e.JumpHosts) > 0 {
+		fields += "\tjump=" + strings.Join(e.JumpHosts, ",")
+	}
+	if e.ProxyCommand != "" {
+		fields += "\tproxy_command=" + strconv.Quote(e.ProxyCommand)
+	}
+	if len(e.Groups) > 0 {
+		fields += "\tgroups=" + strings.Join(e.Groups, ",")
+	}
+	if len(e.Labels) > 0 {
+		fields += "\tlabels=" + labelString(e.Labels)
+	}
+	return fields
+}
+
+// labelString renders labels as sorted name=value pairs.
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseHostEntries reads a legacy hosts file or an inventory. A path:group
+// argument adds group to groups, which require an inventory.
+func parseHostEntries(path string, groups []string) ([]hostEntry, error) {
+	path, group := pssh.SplitInventoryPath(path)
+	if group != "" {
+		groups = slices.Concat([]string{group}, groups)
+	}
+	inventory, err := pssh.IsInventoryFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if inventory {
+		return parseInventoryEntries(path, groups)
+	}
+	if len(groups) > 0 {
+		return nil, fmt.Errorf("%s: groups require an inventory file", path)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = file.Close() }()
+	return parseHostLines(file)
+}
+
+// parseHostLines reads the plain hosts-file format.
+func parseHostLines(r io.Reader) ([]hostEntry, error) {
 	var entries []hostEntry
-	seen := map[string]bool{}
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for line := 1; scanner.Scan(); line++ {
-		text := strings.SplitN(scanner.Text(), "#", 2)[0]
-		for _, value := range strings.Fields(text) {
-			entry := hostEntry{Index: len(entries), Original: value, Line: line}
-			normalized, normalizeErr := normalizeModernHost(value)
-			if normalizeErr != nil {
-				entry.Error = normalizeErr.Error()
-			} else {
-				entry.Normalized = normalized
-				entry.Duplicate = seen[normalized]
-				seen[normalized] = true
-				host, port, _ := net.SplitHostPort(normalized)
-				entry.Host = host
-				entry.Port, _ = strconv.Atoi(port)
-				ip := net.ParseIP(host)
-				switch {
-				case ip == nil:
-					entry.Kind = "dns"
-				case strings.Contains(host, ":"):
-					entry.Kind = "ipv6"
-				default:
-					entry.Kind = "ipv4"
-				}
-			}
-			entries = append(entries, entry)
-		}
+		entries = append(entries, parseHostLine(len(entries), scanner.Text(), line)...)
 	}
+	markDuplicates(entries)
 	return entries, scanner.Err()
 }
 
-func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
+func parseInventoryEntries(path string, groups []string) ([]hostEntry, error) {
+	inventory, err := pssh.ReadInventory(path)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := inventory.Hosts(groups...)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]hostEntry, 0, len(hosts))
+	for _, host := range hosts {
+		entries = append(entries, newInventoryEntries(len(entries), host)...)
+	}
+	markDuplicates(entries)
+	return entries, nil
+}
+
+func runHosts(ctx context.Context, args []string, stdout, stderr io.Writer, globalJSON bool) int {
 	globalJSON = globalJSON || requestedJSON(args)
 	if globalJSON && hasHelp(args) {
 		path := []string{"gopssh", "hosts"}
@@ -1041,15 +2601,24 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 	file := ""
 	jsonMode := globalJSON
 	strict := false
+	resolve := false
+	maxExpansion := defaultMaxExpansion
+	options := defaultRunOptions()
 	fs.StringVar(&file, "file", "", "hosts file")
 	fs.BoolVar(&jsonMode, "json", jsonMode, "JSON output")
+	registerSSHConfigFlags(fs, &options)
+	registerSelectionFlags(fs, &options)
 	if subcommand == "validate" {
-		fs.BoolVar(&strict, "strict", false, "duplicates are errors")
+		fs.BoolVar(&strict, "strict", false, "duplicates and oversized patterns are errors")
+		fs.IntVar(&maxExpansion, "max-expansion", defaultMaxExpansion, "maximum hosts per pattern")
+		fs.BoolVar(&resolve, "resolve", false, "resolve targets through DNS")
+		fs.IntVar(&options.config.Concurrency, "parallel", options.config.Concurrency, "concurrent DNS lookups")
+		fs.IntVar(&options.config.Concurrency, "p", options.config.Concurrency, "concurrent DNS lookups")
 	}
 	if err := fs.Parse(args[1:]); err != nil {
-		known := []string{"--file", "--json"}
+		known := []string{"--file", "--json", "--ssh-config", "--no-ssh-config", "--group", "--select", "--exclude", "--exclude-file"}
 		if subcommand == "validate" {
-			known = append(known, "--strict")
+			known = append(known, "--strict", "--max-expansion", "--resolve", "--parallel", "-p")
 		}
 		return renderUsageError(stdout, stderr, jsonMode, parseFlagError(err, path, known, strings.Join(path, " ")+" --file <path>"))
 	}
@@ -1058,18 +2627,53 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 			"missing_argument", "--file is required and extra arguments are not allowed", path, "", nil, strings.Join(path, " ")+" --file <path>",
 		))
 	}
-	entries, err := parseHostEntries(file)
+	if maxExpansion < 1 || options.config.Concurrency < 1 {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"invalid_argument", "--max-expansion and --parallel must be at least 1", path, "", nil, strings.Join(path, " ")+" --file <path>",
+		))
+	}
+	entries, err := parseHostEntries(file, options.groups)
 	if err != nil {
 		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
 			"hosts_file_not_found", err.Error(), path, file, nil, strings.Join(path, " ")+" --file <path>",
 		))
 	}
+	exclusions, err := exclusionRules(options)
+	if err != nil {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"invalid_argument", err.Error(), path, "--exclude", nil, strings.Join(path, " ")+" --file <path>",
+		))
+	}
+	entries = applyExclusions(entries, exclusions)
+	entries, _, err = selectEntries(entries, options.selects)
+	if err != nil {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"invalid_argument", err.Error(), path, "--select", nil, strings.Join(path, " ")+" --file <path>",
+		))
+	}
+	if err := resolveTargets(entries, options); err != nil {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"ssh_config_invalid", err.Error(), path, options.sshConfig, nil, strings.Join(path, " ")+" --file <path>",
+		))
+	}
+	for i := range entries {
+		if entries[i].Error == "" {
+			entries[i].EffectiveUser = valueOr(entries[i].User, options.config.User)
+			entries[i].EffectiveIdentityFiles = slices.Concat(entries[i].IdentityFiles, pssh.ToSlice(defaultIdentityFiles))
+		}
+	}
+	if resolve {
+		resolveEntries(ctx, entries, options.config.Concurrency, options.config.Timeout, net.DefaultResolver.LookupHost)
+	}
 	errorsCount, warnings := 0, 0
 	for _, entry := range entries {
-		if entry.Error != "" {
+		if entry.Error != "" || entry.ResolveError != "" {
 			errorsCount++
 		}
-		if entry.Duplicate {
+		if len(ipv4Addresses(entry.Addresses)) > 1 {
+			warnings++
+		}
+		if entry.Duplicate || entry.HiddenDuplicateOf != "" {
 			if strict {
 				errorsCount++
 			} else {
@@ -1077,7 +2681,16 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 			}
 		}
 	}
-	if len(entries) == 0 {
+	oversized := oversizedPatterns(entries, maxExpansion)
+	if subcommand == "validate" {
+		if strict {
+			errorsCount += len(oversized)
+		} else {
+			warnings += len(oversized)
+		}
+	}
+	remaining, excluded := withoutExcluded(entries)
+	if len(remaining) == 0 {
 		errorsCount++
 	}
 	if jsonMode {
@@ -1086,14 +2699,17 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 			payload["valid"] = errorsCount == 0
 			payload["errors"] = errorsCount
 			payload["warnings"] = warnings
+			payload["excluded"] = len(excluded)
+			payload["oversized_patterns"] = oversized
 		}
 		if err := json.NewEncoder(stdout).Encode(payload); err != nil {
 			return 1
 		}
 	} else if subcommand == "list" {
 		for _, entry := range entries {
-			if _, err := fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\t%d\t%s\tduplicate=%t\tline=%d\n",
-				entry.Index, entry.Original, entry.Normalized, entry.Kind, entry.Port, entry.Error, entry.Duplicate, entry.Line); err != nil {
+			if _, err := fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\t%d\t%s\tduplicate=%t\tline=%d%s\n",
+				entry.Index, entry.Original, entry.Normalized, entry.Kind, entry.Port, entry.Error, entry.Duplicate, entry.Line,
+				entry.resolvedFields()); err != nil {
 				return 1
 			}
 		}
@@ -1106,11 +2722,24 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 				if _, err := fmt.Fprintf(stderr, "line %d: %s\n", entry.Line, entry.Error); err != nil {
 					return 1
 				}
+			} else if entry.Duplicate && entry.Pattern != "" {
+				if _, err := fmt.Fprintf(stderr, "line %d: duplicate target %s from pattern %s\n", entry.Line, entry.Normalized, entry.Pattern); err != nil {
+					return 1
+				}
 			} else if entry.Duplicate {
 				if _, err := fmt.Fprintf(stderr, "line %d: duplicate target %s\n", entry.Line, entry.Normalized); err != nil {
 					return 1
 				}
 			}
+			if err := writeResolveFindings(stderr, entry); err != nil {
+				return 1
+			}
+		}
+		for _, pattern := range oversized {
+			if _, err := fmt.Fprintf(stderr, "line %d: pattern %s expands to %d hosts (maximum %d)\n",
+				pattern.Line, pattern.Pattern, pattern.Count, maxExpansion); err != nil {
+				return 1
+			}
 		}
 	}
 	if errorsCount != 0 {
@@ -1119,6 +2748,124 @@ func runHosts(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 	return 0
 }
 
+// resolveEntries looks up the host each valid entry connects to, at most
+// parallel at a time, and records the addresses or the error. Entries behind a
+// jump host or proxy command are skipped because the far side resolves them.
+// Distinct targets that share an address and port are marked as hidden
+// duplicates of the first one.
+func resolveEntries(
+	ctx context.Context,
+	entries []hostEntry,
+	parallel int,
+	timeout time.Duration,
+	lookup func(context.Context, string) ([]string, error),
+) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Error != "" || entry.Excluded != "" || len(entry.JumpHosts) > 0 || entry.ProxyCommand != "" {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(valueOr(entry.Address, entry.Normalized))
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			addresses, err := lookup(lookupCtx, host)
+			if err != nil {
+				entry.ResolveError = err.Error()
+				return
+			}
+			entry.Addresses = addresses
+		})
+	}
+	wg.Wait()
+	owners := map[string]string{}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Duplicate {
+			continue
+		}
+		_, port, _ := net.SplitHostPort(valueOr(entry.Address, entry.Normalized))
+		for _, address := range entry.Addresses {
+			key := net.JoinHostPort(address, port)
+			owner, ok := owners[key]
+			if !ok {
+				owners[key] = entry.Normalized
+			} else if owner != entry.Normalized && entry.HiddenDuplicateOf == "" {
+				entry.HiddenDuplicateOf = owner
+			}
+		}
+	}
+}
+
+func ipv4Addresses(addresses []string) []string {
+	var result []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// writeResolveFindings reports the --resolve results of entry.
+func writeResolveFindings(w io.Writer, entry hostEntry) error {
+	if entry.ResolveError != "" {
+		if _, err := fmt.Fprintf(w, "line %d: %s does not resolve: %s\n", entry.Line, entry.Normalized, entry.ResolveError); err != nil {
+			return err
+		}
+	}
+	if ipv4 := ipv4Addresses(entry.Addresses); len(ipv4) > 1 {
+		if _, err := fmt.Fprintf(w, "line %d: %s has %d A records: %s\n",
+			entry.Line, entry.Normalized, len(ipv4), strings.Join(ipv4, ", ")); err != nil {
+			return err
+		}
+	}
+	if entry.HiddenDuplicateOf != "" {
+		if _, err := fmt.Fprintf(w, "line %d: hidden duplicate: %s resolves to the same address as %s\n",
+			entry.Line, entry.Normalized, entry.HiddenDuplicateOf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type hostPattern struct {
+	Pattern string `json:"pattern"`
+	Line    int    `json:"line"`
+	Count   int    `json:"count"`
+}
+
+// oversizedPatterns returns the patterns that expanded to more than limit
+// entries, in input order.
+func oversizedPatterns(entries []hostEntry, limit int) []hostPattern {
+	patterns := []hostPattern{}
+	index := map[hostPattern]int{}
+	for _, entry := range entries {
+		if entry.Pattern == "" {
+			continue
+		}
+		key := hostPattern{Pattern: entry.Pattern, Line: entry.Line}
+		i, ok := index[key]
+		if !ok {
+			i = len(patterns)
+			index[key] = i
+			patterns = append(patterns, key)
+		}
+		patterns[i].Count++
+	}
+	oversized := []hostPattern{}
+	for _, pattern := range patterns {
+		if pattern.Count > limit {
+			oversized = append(oversized, pattern)
+		}
+	}
+	return oversized
+}
+
 type doctorCheck struct {
 	Name     string `json:"name"`
 	OK       bool   `json:"ok"`
@@ -1126,7 +2873,7 @@ type doctorCheck struct {
 	Message  string `json:"message"`
 }
 
-func runDoctor(ctx context.Context, args []string, stdout, stderr io.Writer, globalJSON bool) int {
+func runDoctor(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, globalJSON bool) int {
 	jsonMode := globalJSON || requestedJSON(args)
 	if hasHelp(args) {
 		if jsonMode {
@@ -1144,10 +2891,14 @@ func runDoctor(ctx context.Context, args []string, stdout, stderr io.Writer, glo
 	fs.SetOutput(io.Discard)
 	fs.StringVar(&options.hostsFile, "hosts-file", "", "hosts file")
 	fs.StringVar(&options.hostsFile, "H", "", "hosts file")
+	fs.StringVar(&options.hostsExec, "hosts-exec", "", "dynamic inventory command")
 	fs.StringVar(&options.config.User, "user", options.config.User, "SSH user")
 	fs.Var(&options.identities, "identity", "identity file")
 	fs.BoolVar(&options.config.IdentityFileOnly, "identities-only", false, "disable agent")
+	fs.BoolVar(&options.config.ForwardAgent, "forward-agent", false, "forward agent")
+	fs.BoolVar(&options.config.ForwardAgent, "A", false, "forward agent")
 	fs.BoolVar(&options.config.IgnoreHostKey, "insecure-ignore-host-key", false, "skip known_hosts")
+	registerHostKeyFlags(fs, &options)
 	fs.DurationVar(&options.config.Timeout, "connect-timeout", options.config.Timeout, "connect timeout")
 	fs.IntVar(&options.config.Concurrency, "parallel", options.config.Concurrency, "parallel connections")
 	fs.IntVar(&options.config.MaxAgentConns, "max-agent-connections", options.config.MaxAgentConns, "agent connections")
@@ -1161,13 +2912,23 @@ func runDoctor(ctx context.Context, args []string, stdout, stderr io.Writer, glo
 	fs.BoolVar(&connect, "connect", false, "perform network diagnostics")
 	fs.IntVar(&limit, "limit", limit, "target limit")
 	fs.BoolVar(&jsonMode, "json", jsonMode, "JSON output")
+	registerSSHConfigFlags(fs, &options)
+	registerJumpFlags(fs, &options)
+	registerProxyFlags(fs, &options)
+	registerPasswordFlags(fs, &options)
+	registerCertificateFlags(fs, &options)
+	registerSelectionFlags(fs, &options)
 	if err := fs.Parse(args); err != nil {
 		known := []string{
-			"--hosts-file", "-H", "--user", "--identity", "--identities-only",
-			"--insecure-ignore-host-key", "--connect-timeout", "--parallel",
+			"--hosts-file", "-H", "--hosts-exec", "--user", "--identity", "--identities-only",
+			"--forward-agent", "-A", "--insecure-ignore-host-key", "--host-ca-file", "--known-hosts", "--host-key-policy",
+			"--connect-timeout", "--parallel",
 			"--max-agent-connections", "--max-buffer-memory", "--max-spool-size",
 			"--spool-dir", "--legacy-crypto", "--kex", "--ciphers", "--macs",
-			"--connect", "--limit", "--json",
+			"--connect", "--limit", "--json", "--ssh-config", "--no-ssh-config",
+			"--jump", "-J", "--proxy-command", "--proxy", "--group", "--select",
+			"--exclude", "--exclude-file", "--ask-pass", "--password-file", "--password-env",
+			"--identity-passphrase-file", "--certificate",
 		}
 		return renderUsageError(stdout, stderr, jsonMode, parseFlagError(err, []string{"gopssh", "doctor"}, known, "gopssh doctor [options]"))
 	}
@@ -1177,19 +2938,28 @@ func runDoctor(ctx context.Context, args []string, stdout, stderr io.Writer, glo
 			[]string{"gopssh", "doctor"}, "", nil, "gopssh doctor [options]",
 		))
 	}
-	fs.Visit(func(parsed *flag.Flag) {
-		if parsed.Name == "identity" {
-			options.identitySet = true
-		}
-	})
+	applyProxyEnvironment(fs, &options, stderr)
+	if err := errors.Join(applyHostKeyPolicy(&options), validateTargetOptions(options)); err != nil {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"invalid_argument", err.Error(), []string{"gopssh", "doctor"}, "", nil, "gopssh doctor [options]",
+		))
+	}
+	options.identitySet = flagWasSet(fs, "identity")
+	options.userSet = flagWasSet(fs, "user")
 	if len(options.identities) == 0 {
 		options.identities = pssh.ToSlice(defaultIdentityFiles)
 	}
 	configureCrypto(&options.config, options.legacyCrypto, options.kex, options.ciphers, options.macs)
-	var targets []string
+	var targets []hostEntry
 	var targetsErr error
 	if connect {
-		targets, targetsErr = loadTargets(options.hostsFile, nil)
+		targets, _, targetsErr = loadTargets(ctx, &options, stdin)
+		if targetsErr == nil {
+			targets, _, targetsErr = selectEntries(targets, options.selects)
+		}
+		if targetsErr == nil {
+			targetsErr = resolveTargets(targets, options)
+		}
 		if targetsErr == nil && len(targets) == 0 {
 			return renderUsageError(stdout, stderr, jsonMode, newUsageError(
 				"missing_argument", "at least one target is required when --connect is set; use --hosts-file",
@@ -1202,16 +2972,20 @@ func runDoctor(ctx context.Context, args []string, stdout, stderr io.Writer, glo
 	if connect {
 		if targetsErr != nil {
 			checks = append(checks, doctorCheck{Name: "network", OK: false, Required: true, Message: targetsErr.Error()})
+		} else if closePrompt, secretErr := loadSecrets(&options, true); secretErr != nil {
+			checks = append(checks, doctorCheck{Name: "network", OK: false, Required: true, Message: secretErr.Message})
 		} else {
+			defer closePrompt()
 			options.config.IdentFiles = options.identities
+			options.config.CertificateFiles = options.certificates
 			probe := &pssh.Pssh{Config: &options.config}
-			for i, target := range targets {
+			for i, host := range entryHosts(targets) {
 				if i >= limit {
 					break
 				}
-				probeErr := probe.ProbeContext(ctx, target)
+				probeErr := probe.ProbeHostContext(ctx, host)
 				checks = append(checks, doctorCheck{
-					Name: "ssh:" + target, OK: probeErr == nil, Required: true,
+					Name: "ssh:" + host.Target, OK: probeErr == nil, Required: true,
 					Message: errorString(probeErr, "handshake and authentication succeeded"),
 				})
 			}
@@ -1284,39 +3058,61 @@ func doctorChecks(options runOptions, stdout, stderr io.Writer) []doctorCheck {
 		socketMessage = "disabled by --identities-only"
 	}
 	checks = append(checks, doctorCheck{Name: "ssh_agent", OK: socketOK, Message: socketMessage})
+	if options.config.ForwardAgent {
+		checks = append(checks, agentForwardingCheck(options, socketOK, socketMessage))
+	}
 	readableIdentity := false
+	passphrase, passphraseErr := pssh.Secret(""), error(nil)
+	if options.identityPassphraseFile != "" {
+		var value string
+		value, passphraseErr = readSecretFile(options.identityPassphraseFile)
+		passphrase = pssh.Secret(value)
+		checks = append(checks, doctorCheck{
+			Name: "identity_passphrase_file", OK: passphraseErr == nil, Required: true,
+			Message: errorString(passphraseErr, options.identityPassphraseFile),
+		})
+	}
 	for _, identity := range options.identities {
 		expanded := expandHome(identity)
-		file, err := os.Open(expanded)
+		data, err := os.ReadFile(expanded)
+		message := ""
+		if err == nil {
+			message, err = inspectIdentity(data, passphrase, passphraseErr == nil)
+		}
 		ok := err == nil
-		if ok {
-			readableIdentity = true
-			_ = file.Close()
+		readableIdentity = readableIdentity || ok
+		if err != nil {
+			message = err.Error()
 		}
-		message := errorString(err, "readable")
-		if err != nil && !options.identitySet {
+		if errors.Is(err, os.ErrNotExist) && !options.identitySet {
 			message = "optional default not found: " + expanded
 		}
 		checks = append(checks, doctorCheck{
 			Name: "identity:" + identity, OK: ok, Required: options.identitySet, Message: message,
 		})
 	}
-	knownHosts := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
-	knownFile, knownErr := os.Open(knownHosts)
-	if knownFile != nil {
-		_ = knownFile.Close()
+	checks = append(checks, certificateChecks(options, socketOK)...)
+	checks = append(checks, knownHostsChecks(options.config)...)
+	for _, file := range options.config.HostCAFiles {
+		authorities, err := pssh.ReadHostCAFile(file)
+		checks = append(checks, doctorCheck{
+			Name: "host_ca:" + file, OK: err == nil && len(authorities) > 0, Required: true,
+			Message: errorString(err, fmt.Sprintf("%d host CA keys", len(authorities))),
+		})
 	}
-	checks = append(checks, doctorCheck{
-		Name: "known_hosts", OK: options.config.IgnoreHostKey || knownErr == nil, Required: true,
-		Message: errorString(knownErr, knownHosts),
-	})
-	if options.hostsFile != "" {
+	if options.hostsFile != "" && options.hostsFile != "-" {
 		targets, err := pssh.ReadHosts(options.hostsFile)
 		checks = append(checks, doctorCheck{
 			Name: "hosts_file", OK: err == nil && len(targets) > 0, Required: true,
 			Message: errorString(err, fmt.Sprintf("%d targets", len(targets))),
 		})
 	}
+	passwordOK := false
+	if passwordSource(options) != "" {
+		check := doctorPasswordCheck(options)
+		passwordOK = check.OK
+		checks = append(checks, check)
+	}
 	parent := options.config.SpoolDir
 	tempDir, err := os.MkdirTemp(parent, "gopssh-doctor-*")
 	if err == nil {
@@ -1327,12 +3123,149 @@ func doctorChecks(options runOptions, stdout, stderr io.Writer) []doctorCheck {
 		Message: errorString(err, valueOr(parent, os.TempDir())),
 	})
 	checks = append(checks, doctorCheck{
-		Name: "authentication", OK: socketOK || readableIdentity, Required: true,
+		Name: "authentication", OK: socketOK || readableIdentity || passwordOK, Required: true,
 		Message: "at least one usable authentication source",
 	})
 	return checks
 }
 
+// agentForwardingCheck reports that --forward-agent was requested. The agent
+// is forwarded even with --identities-only, so it is probed again then.
+func agentForwardingCheck(options runOptions, socketOK bool, socketMessage string) doctorCheck {
+	socket := options.config.SSHAuthSocket
+	if socket == "" {
+		return doctorCheck{Name: "agent_forwarding", Required: true, Message: "requested but SSH_AUTH_SOCK is not set"}
+	}
+	if options.config.IdentityFileOnly {
+		probe := options.agentProbe
+		if probe == nil {
+			probe = probeAgentSocket
+		}
+		err := probe(socket)
+		socketOK, socketMessage = err == nil, errorString(err, "available")
+	}
+	message := "requested; forwards " + socket
+	if !socketOK {
+		message = "requested but the SSH Agent is unavailable: " + socketMessage
+	}
+	return doctorCheck{Name: "agent_forwarding", OK: socketOK, Required: true, Message: message}
+}
+
+// knownHostsChecks reports each known_hosts file. Under accept-new the
+// first file may be missing: it is created on the first new key.
+func knownHostsChecks(config pssh.Config) []doctorCheck {
+	policy := config.EffectiveHostKeyPolicy()
+	files := config.EffectiveKnownHostsFiles()
+	checks := make([]doctorCheck, 0, len(files))
+	for i, file := range files {
+		name := "known_hosts"
+		if len(config.KnownHostsFiles) > 0 {
+			name += ":" + file
+		}
+		knownFile, err := os.Open(file)
+		if knownFile != nil {
+			_ = knownFile.Close()
+		}
+		message := errorString(err, file)
+		if i == 0 && policy == pssh.HostKeyPolicyAcceptNew {
+			message = errorString(err, file+"; records new host keys")
+			if errors.Is(err, os.ErrNotExist) {
+				err, message = nil, file+" is created on the first new host key"
+			}
+		}
+		checks = append(checks, doctorCheck{
+			Name: name, OK: policy == pssh.HostKeyPolicyInsecure || err == nil, Required: true,
+			Message: message + " (" + policy + ")",
+		})
+	}
+	return checks
+}
+
+// certificateChecks describes the certificates next to the identity files,
+// those of --certificate and those held by the agent. Each fails when the
+// certificate is expired or not yet valid.
+func certificateChecks(options runOptions, agentOK bool) []doctorCheck {
+	var checks []doctorCheck
+	now := time.Now()
+	paths := slices.Clone([]string(options.certificates))
+	for _, identity := range options.identities {
+		path := expandHome(identity) + pssh.CertificateSuffix
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	for _, path := range paths {
+		cert, err := pssh.ReadCertificate(expandHome(path))
+		message := ""
+		if err == nil {
+			message, err = pssh.DescribeCertificate(cert, now)
+		}
+		checks = append(checks, doctorCheck{
+			Name: "certificate:" + path, OK: err == nil, Required: true,
+			Message: strings.TrimSpace(message + " " + errorString(err, "")),
+		})
+	}
+	if !agentOK {
+		return checks
+	}
+	certs, _ := pssh.ListAgentCertificates(options.config.SSHAuthSocket)
+	for _, cert := range certs {
+		message, err := pssh.DescribeCertificate(cert, now)
+		checks = append(checks, doctorCheck{
+			Name: "agent_certificate:" + cert.KeyId, OK: err == nil, Required: true,
+			Message: strings.TrimSpace(message + " " + errorString(err, "")),
+		})
+	}
+	return checks
+}
+
+// inspectIdentity describes an identity file for doctor. Encrypted keys are
+// usable when the passphrase decrypts them or, without
+// --identity-passphrase-file, when a terminal can ask for it.
+func inspectIdentity(data []byte, passphrase pssh.Secret, passphraseOK bool) (string, error) {
+	signer, encrypted, err := pssh.ParseIdentity(data, passphrase)
+	switch {
+	case !encrypted && err != nil:
+		return "", fmt.Errorf("cannot be parsed: %w", err)
+	case !encrypted:
+		return signer.PublicKey().Type() + " key", nil
+	case errors.Is(err, pssh.ErrPassphraseRequired):
+		if !passphraseOK {
+			return "", errors.New("encrypted; --identity-passphrase-file is unreadable")
+		}
+		if tty, ttyErr := openTTY(); ttyErr == nil {
+			_ = tty.Close()
+			return "encrypted; the passphrase is asked once on the terminal", nil
+		}
+		return "", errors.New("encrypted; use --identity-passphrase-file or run from a terminal")
+	case err != nil:
+		return "", fmt.Errorf("encrypted; --identity-passphrase-file does not decrypt it: %w", err)
+	}
+	return "encrypted " + signer.PublicKey().Type() + " key; decrypted with --identity-passphrase-file", nil
+}
+
+// doctorPasswordCheck reports whether the password source is usable. It
+// names the source only; --ask-pass checks for a terminal without prompting.
+func doctorPasswordCheck(options runOptions) doctorCheck {
+	check := doctorCheck{Name: "password", Required: true, Message: passwordSource(options)}
+	if options.askPass && options.prompt == nil {
+		tty, err := openTTY()
+		if err != nil {
+			check.Message = err.Error()
+			return check
+		}
+		_ = tty.Close()
+		check.OK = true
+		return check
+	}
+	if err := loadPassword(&options, false); err != nil {
+		check.Message = err.Message
+		return check
+	}
+	check.OK = true
+	return check
+}
+
 func probeAgentSocket(socket string) error {
 	conn, err := net.DialTimeout("unix", socket, time.Second)
 	if err != nil {
@@ -1393,7 +3326,8 @@ func runConfig(args []string, stdout, stderr io.Writer, globalJSON bool) int {
 		{"name": "max_agent_connections", "value": options.config.MaxAgentConns, "source": "default"},
 		{"name": "identity_files", "value": []string(options.identities), "source": "default"},
 		{"name": "identities_only", "value": false, "source": "default"},
-		{"name": "host_key_policy", "value": "known-hosts", "source": "default"},
+		{"name": "host_key_policy", "value": pssh.HostKeyPolicyStrict, "source": "default"},
+		{"name": "known_hosts_files", "value": options.config.EffectiveKnownHostsFiles(), "source": "default"},
 		{"name": "connect_timeout", "value": options.config.Timeout.String(), "source": "default"},
 		{"name": "output_order", "value": options.order, "source": "default"},
 		{"name": "color", "value": options.color, "source": "default"},
@@ -1477,13 +3411,13 @@ func runCompletion(args []string, stdout, stderr io.Writer, jsonMode bool) int {
 	var err error
 	switch args[0] {
 	case "bash":
-		_, err = fmt.Fprintln(stdout, `complete -W "run doctor hosts config version completion help" gopssh`)
+		_, err = fmt.Fprintln(stdout, `complete -W "run doctor hosts known-hosts config version completion help" gopssh`)
 	case "zsh":
-		_, err = fmt.Fprintln(stdout, `compctl -k "(run doctor hosts config version completion help)" gopssh`)
+		_, err = fmt.Fprintln(stdout, `compctl -k "(run doctor hosts known-hosts config version completion help)" gopssh`)
 	case "fish":
-		_, err = fmt.Fprintln(stdout, `complete -c gopssh -f -a "run doctor hosts config version completion help"`)
+		_, err = fmt.Fprintln(stdout, `complete -c gopssh -f -a "run doctor hosts known-hosts config version completion help"`)
 	case "powershell":
-		_, err = fmt.Fprintln(stdout, `Register-ArgumentCompleter -CommandName gopssh -ScriptBlock { param($w) "run","doctor","hosts","config","version","completion","help" | ? { $_ -like "$w*" } }`)
+		_, err = fmt.Fprintln(stdout, `Register-ArgumentCompleter -CommandName gopssh -ScriptBlock { param($w) "run","doctor","hosts","known-hosts","config","version","completion","help" | ? { $_ -like "$w*" } }`)
 	}
 	if err != nil {
 		return 1
@@ -1545,9 +3479,10 @@ func controlScanKnownFlag(name string) bool {
 		return true
 	}
 	switch name {
-	case "-h", "--help", "--identities-only", "--show-host",
+	case "-h", "--help", "--identities-only", "--forward-agent", "-A", "--show-host", "--stop-on-failure", "--yes",
 		"--insecure-ignore-host-key", "--legacy-crypto", "--debug",
-		"--dry-run", "--json", "--stdin", "--connect", "--strict":
+		"--dry-run", "--json", "--stdin", "--connect", "--strict",
+		"--no-ssh-config", "--resolve", "--ask-pass":
 		return true
 	default:
 		return false
@@ -1558,10 +3493,15 @@ func controlScanFlagTakesValue(name string) bool {
 	switch name {
 	case "--hosts-file", "-H", "--host", "--user", "-u", "--parallel", "-p",
 		"--max-agent-connections", "--identity", "-i", "--connect-timeout",
+		"--command-timeout", "--deadline", "--grace-period",
 		"--order", "--color", "--kex", "--ciphers", "--macs",
 		"--max-buffer-memory", "--max-spool-size", "--spool-dir",
-		"--output-dir", "--exit-policy", "--command", "--stdin-file",
-		"--file", "--limit":
+		"--output-dir", "--exit-policy", "--command", "--script", "--step", "--stdin-file",
+		"--batch-size", "--max-fail", "--batch-pause", "--canary", "--canary-host",
+		"--file", "--limit", "--ssh-config", "--jump", "-J", "--proxy-command", "--hosts-exec",
+		"--proxy", "--group", "--select", "--max-expansion", "--exclude", "--exclude-file",
+		"--password-file", "--password-env", "--identity-passphrase-file", "--certificate",
+		"--host-ca-file", "--known-hosts", "--host-key-policy":
 		return true
 	default:
 		return false
@@ -1686,6 +3626,10 @@ func helpForPath(path []string) string {
 		return hostsListHelpText()
 	case "gopssh hosts validate":
 		return hostsValidateHelpText()
+	case "gopssh known-hosts":
+		return knownHostsHelpText()
+	case "gopssh known-hosts scan":
+		return knownHostsScanHelpText()
 	case "gopssh config":
 		return configHelpText()
 	case "gopssh config show":
@@ -1711,6 +3655,7 @@ Commands:
   run          Run a remote command
   doctor       Diagnose local SSH configuration without connecting by default
   hosts        List or validate a hosts file without DNS or network access
+  known-hosts  Collect host keys of targets in known_hosts format
   config       Show effective settings and their sources
   version      Show build information
   completion   Generate shell completion
@@ -1758,28 +3703,89 @@ Use the subcommand syntax for new scripts:
 }
 
 func runHelpText() string {
-	return `Run one command on every target.
+	return `Run one command, or an ordered list of commands, on every target.
 
 Usage:
   gopssh run [options] -- command [arguments...]
   gopssh run [options] --command '<shell command>'
+  gopssh run [options] --script PATH
 
 Required:
-  -H, --hosts-file PATH       Read legacy-format targets from PATH
-      --host HOST[:PORT]      Add one target; repeatable
+  -H, --hosts-file PATH[:GROUP]  Read targets from a hosts file or an INI/YAML
+                              inventory, optionally limited to GROUP;
+                              - reads plain lines or JSON from stdin
+      --hosts-exec COMMAND    Read targets from the output of COMMAND
+      --host [USER@]HOST[:PORT]  Add one target; repeatable. Options may follow,
+                              e.g. 'db1 user=admin identity=~/.ssh/legacy_rsa'
+  Targets may use patterns such as web[01-20].example.com,
+  rack[a-c] and {web,db}1; each expands to one target per host.
   A command and at least one target are required.
 
 Options:
+      --group NAME            Select an inventory group; repeatable
+      --select SELECTOR       Keep targets whose labels match, e.g.
+                              'role=web,env!=prod,tier in (a,b),!canary'
+      --exclude HOST[:PORT]   Skip a target or pattern; without a port every
+                              port of HOST is skipped; repeatable
+      --exclude-file PATH     Skip the targets listed in PATH
   -u, --user USER             SSH user (default: $USER)
   -p, --parallel N            Concurrent SSH connections (default: 32)
       --max-agent-connections N  Concurrent agent connections (default: 50)
   -i, --identity PATH         Identity file; repeatable
       --identities-only       Disable SSH Agent authentication
+  -A, --forward-agent         Forward the SSH Agent to every target session
+                              through the --max-agent-connections limit; the
+                              targets can use its keys while commands run
+      --ask-pass              Prompt once on the terminal for a password used by
+                              every target; also answers one-time-code prompts
+      --password-file PATH    Read the password from PATH
+      --password-env NAME     Read the password from environment variable NAME
+      --identity-passphrase-file PATH  Decrypt encrypted identity files with the
+                              passphrase in PATH instead of asking once per key
+      --certificate PATH      User certificate offered with the identity or agent
+                              key it certifies; repeatable (each identity's own
+                              -cert.pub is always loaded)
+      --ssh-config PATH       Resolve Host aliases through PATH (default: ~/.ssh/config)
+      --no-ssh-config         Do not read an ssh_config file
+  -J, --jump [USER@]HOST[:PORT]  Connect through a bastion; repeat for a chain
+                              (overrides ssh_config ProxyJump)
+      --proxy-command COMMAND Connect through COMMAND's stdin/stdout; %h, %p
+                              and %r expand to host, port and user
+      --proxy URL             socks5://, socks5h://, http:// or https:// proxy,
+                              optionally with USER:PASSWORD@ (default: $ALL_PROXY
+                              except for $NO_PROXY; 'none' disables it)
       --connect-timeout DURATION (default: 15s)
+      --command-timeout DURATION  Stop each command that runs longer (default: none)
+      --deadline DURATION     Stop the whole run after DURATION; targets and
+                              steps not started are reported as timeout
+      --grace-period DURATION Wait after sending SIGTERM to a command out of
+                              time, or forwarding Ctrl-C or SIGTERM, before
+                              closing its session (default: 5s)
       --show-host             Print target and exit code to stderr
       --order input|completion (default: input)
       --color auto|always|never (default: auto)
       --insecure-ignore-host-key  Skip known_hosts verification; permits MITM attacks
+      --host-ca-file PATH     Trust host certificates signed by the CA keys in
+                              PATH (public keys or @cert-authority lines);
+                              repeatable
+      --known-hosts FILE      Repeatable known_hosts file; the first records new
+                              keys (default: ~/.ssh/known_hosts)
+      --host-key-policy strict|accept-new|insecure (default: strict)
+      --script PATH           Run each line of PATH as a step over one connection
+                              per target; blank lines and # comments are skipped
+      --step COMMAND          Run COMMAND as the next step; repeatable
+      --stop-on-failure       Skip the remaining steps of a target after one fails
+      --batch-size N|P%       Run targets in batches; each batch finishes before
+                              the next one starts
+      --batch-pause DURATION  Wait between batches (default: 0s)
+      --max-fail N|P%         Stop starting targets once more than N, or P% of
+                              all, have failed; the rest are reported as canceled
+      --canary N              Run the first N targets first and start the others
+                              only if they succeed under --exit-policy
+      --canary-host HOST      Run targets matching HOST first, like --canary;
+                              repeatable, supports {a,b} and [1-3]
+      --yes                   Continue after passing canaries without asking on
+                              the terminal
       --stdin                 Forward process stdin (maximum: 64MiB)
       --stdin-file PATH       Forward a file (maximum: 64MiB)
       --dry-run               Validate and print the plan without connecting
@@ -1800,6 +3806,9 @@ Examples:
   gopssh run --hosts-file hosts.txt -- uptime
   gopssh run --host host1 --dry-run -- printf '%s\n' 'hello world'
   gopssh run --hosts-file hosts.txt --command 'sudo systemctl status app'
+  gopssh run --hosts-file hosts.txt --step 'apt-get update' --step 'apt-get -y upgrade' --stop-on-failure
+  gopssh run --hosts-file hosts.txt --batch-size 10% --max-fail 2 -- ./deploy.sh
+  gopssh run --hosts-file hosts.txt --canary 2 -- ./deploy.sh
 `
 }
 
@@ -1810,10 +3819,30 @@ Usage:
   gopssh doctor [options]
 
 Options:
-  -H, --hosts-file PATH
+  -H, --hosts-file PATH[:GROUP]
+      --hosts-exec COMMAND   Read targets from the output of COMMAND
+      --group NAME           Repeatable inventory group
+      --select SELECTOR      Label selector for --connect targets
+      --exclude HOST[:PORT]  Repeatable excluded target or pattern
+      --exclude-file PATH
       --identity PATH         Repeatable
       --identities-only
+  -A, --forward-agent        Check that the agent to forward is available
+      --ask-pass             Prompt for the password with --connect
+      --password-file PATH
+      --password-env NAME
+      --identity-passphrase-file PATH
+      --certificate PATH     Repeatable user certificate
       --insecure-ignore-host-key
+      --host-ca-file PATH    Repeatable trusted host CA keys
+      --known-hosts FILE     Repeatable known_hosts file
+      --host-key-policy strict|accept-new|insecure
+      --ssh-config PATH      Resolve Host aliases through PATH (default: ~/.ssh/config)
+      --no-ssh-config
+  -J, --jump [USER@]HOST[:PORT]  Repeatable bastion chain
+      --proxy-command COMMAND
+      --proxy URL            SOCKS5 or HTTP CONNECT proxy (default: $ALL_PROXY
+                             except for $NO_PROXY)
       --connect              Opt in to SSH handshake and authentication checks
       --limit N              Maximum targets checked with --connect (default: 10)
       --json
@@ -1825,7 +3854,8 @@ Example:
 }
 
 func hostsHelpText() string {
-	return `Inspect hosts files locally without DNS or network access.
+	return `Inspect hosts files locally. No DNS or network access occurs unless
+hosts validate --resolve is set.
 
 Usage:
   gopssh hosts <command> [options]
@@ -1836,13 +3866,21 @@ Commands:
 
 Examples:
   gopssh hosts list --file hosts.txt
+  gopssh hosts list --file inventory.yaml --group web
   gopssh hosts validate --file hosts.txt
 `
 }
 
 func hostsListHelpText() string {
 	return `Usage:
-  gopssh hosts list --file PATH [--json]
+  gopssh hosts list --file PATH[:GROUP] [--group NAME] [--select SELECTOR] [--exclude HOST] [--exclude-file PATH] [--ssh-config PATH | --no-ssh-config] [--json]
+
+Targets are resolved through ~/.ssh/config unless --no-ssh-config is set.
+INI and YAML inventories list each host's groups, labels and variables.
+Hosts expanded from a pattern such as web[01-20] show the pattern and line.
+Targets removed by !host entries, --exclude or --exclude-file are listed
+with excluded= and the rule that matched. JSON entries include the
+effective_user and effective_identity_files each target connects with.
 
 Example:
   gopssh hosts list --file hosts.txt
@@ -1851,12 +3889,21 @@ Example:
 
 func hostsValidateHelpText() string {
 	return `Usage:
-  gopssh hosts validate --file PATH [--strict] [--json]
+  gopssh hosts validate --file PATH[:GROUP] [--group NAME] [--select SELECTOR] [--exclude HOST] [--exclude-file PATH] [--strict] [--max-expansion N] [--resolve [--parallel N]] [--ssh-config PATH | --no-ssh-config] [--json]
+
+Duplicates and patterns that expand to more than --max-expansion hosts
+(default 1024) are warnings unless --strict is specified.
 
-Duplicates are warnings unless --strict is specified.
+--resolve looks up every target through DNS, --parallel N at a time
+(default: 32). A name that does not resolve is an error; several A records
+are a warning; distinct names that resolve to the same address and port are
+hidden duplicates, which --strict turns into errors. Targets behind a jump
+host or proxy command are not resolved.
 
 Example:
   gopssh hosts validate --file hosts.txt --strict
+  gopssh hosts validate --file hosts.txt --strict --max-expansion 200
+  gopssh hosts validate --file hosts.txt --resolve --parallel 64
 `
 }
 
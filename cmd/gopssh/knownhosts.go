@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/masahide/gopssh/pkg/pssh"
+	"golang.org/x/crypto/ssh"
+)
+
+type scannedKey struct {
+	Type        string   `json:"type"`
+	Fingerprint string   `json:"fingerprint"`
+	KnownHosts  string   `json:"known_hosts"`
+	Status      string   `json:"status,omitempty"`
+	Recorded    []string `json:"recorded,omitempty"`
+}
+
+type scannedHost struct {
+	Target  string       `json:"target"`
+	Address string       `json:"address"`
+	Keys    []scannedKey `json:"keys"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type scanSummary struct {
+	Scanned int `json:"scanned"`
+	Failed  int `json:"failed"`
+	New     int `json:"new"`
+	Known   int `json:"known"`
+	Changed int `json:"changed"`
+	Written int `json:"written"`
+}
+
+func knownHostsUsage() string     { return "gopssh known-hosts <scan> [options]" }
+func knownHostsScanUsage() string { return "gopssh known-hosts scan [options]" }
+
+func runKnownHosts(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, globalJSON bool) int {
+	globalJSON = globalJSON || requestedJSON(args)
+	if globalJSON && hasHelp(args) {
+		path, usage := []string{"gopssh", "known-hosts"}, knownHostsUsage()
+		if len(args) > 0 && args[0] == "scan" {
+			path, usage = append(path, "scan"), knownHostsScanUsage()
+		}
+		return renderJSONHelpError(stdout, stderr, path, usage)
+	}
+	if len(args) == 0 {
+		return renderUsageError(stdout, stderr, globalJSON, newUsageError(
+			"missing_argument", "known-hosts subcommand is required", []string{"gopssh", "known-hosts"}, "", nil, knownHostsUsage(),
+		))
+	}
+	if hasHelp(args[:1]) {
+		if _, err := fmt.Fprint(stdout, knownHostsHelpText()); err != nil {
+			return 1
+		}
+		return 0
+	}
+	if args[0] != "scan" {
+		return renderUsageError(stdout, stderr, globalJSON, newUsageError(
+			"unknown_subcommand", fmt.Sprintf("unknown subcommand %q for %q", args[0], "gopssh known-hosts"),
+			[]string{"gopssh", "known-hosts"}, args[0], suggest(args[0], []string{"scan"}), knownHostsUsage(),
+		))
+	}
+	if hasHelp(args[1:]) {
+		if _, err := fmt.Fprint(stdout, knownHostsScanHelpText()); err != nil {
+			return 1
+		}
+		return 0
+	}
+	return runKnownHostsScan(ctx, args[1:], stdin, stdout, stderr, globalJSON)
+}
+
+// runKnownHostsScan fetches the host keys of the targets and prints them as
+// known_hosts lines or JSON. --diff compares them with the known_hosts files
+// and --write records the new ones in the first file.
+func runKnownHostsScan(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, jsonMode bool) int {
+	path := []string{"gopssh", "known-hosts", "scan"}
+	options := defaultRunOptions()
+	types := strings.Join(pssh.DefaultHostKeyScanTypes, ",")
+	hash, diff, write := false, false, false
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&options.hostsFile, "hosts-file", "", "hosts file")
+	fs.StringVar(&options.hostsFile, "H", "", "hosts file")
+	fs.StringVar(&options.hostsExec, "hosts-exec", "", "dynamic inventory command")
+	fs.Var(&options.hosts, "host", "target")
+	fs.StringVar(&options.config.User, "user", options.config.User, "SSH user")
+	fs.StringVar(&options.config.User, "u", options.config.User, "SSH user")
+	fs.IntVar(&options.config.Concurrency, "parallel", options.config.Concurrency, "parallel connections")
+	fs.IntVar(&options.config.Concurrency, "p", options.config.Concurrency, "parallel connections")
+	fs.Var(&options.identities, "identity", "identity file")
+	fs.Var(&options.identities, "i", "identity file")
+	fs.BoolVar(&options.config.IdentityFileOnly, "identities-only", false, "disable SSH Agent")
+	fs.StringVar(&options.identityPassphraseFile, "identity-passphrase-file", "", "decrypt identity files with the passphrase in a file")
+	fs.DurationVar(&options.config.Timeout, "connect-timeout", options.config.Timeout, "connect timeout")
+	fs.BoolVar(&options.config.IgnoreHostKey, "insecure-ignore-host-key", false, "skip known_hosts verification of jump hosts")
+	registerHostKeyFlags(fs, &options)
+	fs.StringVar(&types, "type", types, "key types")
+	fs.BoolVar(&hash, "hash", false, "hash host names")
+	fs.BoolVar(&diff, "diff", false, "compare with known_hosts")
+	fs.BoolVar(&write, "write", false, "record new keys")
+	fs.BoolVar(&jsonMode, "json", jsonMode, "JSON output")
+	registerSSHConfigFlags(fs, &options)
+	registerJumpFlags(fs, &options)
+	registerProxyFlags(fs, &options)
+	registerSelectionFlags(fs, &options)
+	if err := fs.Parse(args); err != nil {
+		known := []string{
+			"--hosts-file", "-H", "--hosts-exec", "--host", "--user", "-u", "--parallel", "-p",
+			"--identity", "-i", "--identities-only", "--identity-passphrase-file", "--connect-timeout",
+			"--insecure-ignore-host-key", "--host-ca-file", "--known-hosts", "--host-key-policy",
+			"--type", "--hash", "--diff", "--write", "--json", "--ssh-config", "--no-ssh-config",
+			"--jump", "-J", "--proxy-command", "--proxy", "--group", "--select", "--exclude", "--exclude-file",
+		}
+		return renderUsageError(stdout, stderr, jsonMode, parseFlagError(err, path, known, knownHostsScanUsage()))
+	}
+	invalid := func(err error, token string) int {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"invalid_argument", err.Error(), path, token, nil, knownHostsScanUsage(),
+		))
+	}
+	if fs.NArg() != 0 {
+		return invalid(errors.New("extra arguments are not allowed"), fs.Arg(0))
+	}
+	if options.config.Concurrency < 1 {
+		return invalid(errors.New("--parallel must be at least 1"), "--parallel")
+	}
+	scanTypes := pssh.ToSlice(types)
+	for _, name := range scanTypes {
+		if _, ok := pssh.HostKeyScanTypes[name]; !ok {
+			return invalid(fmt.Errorf("--type must list ed25519, ecdsa or rsa, not %q", name), "--type")
+		}
+	}
+	applyProxyEnvironment(fs, &options, stderr)
+	if err := errors.Join(applyHostKeyPolicy(&options), validateTargetOptions(options)); err != nil {
+		return invalid(err, "")
+	}
+	options.userSet = flagWasSet(fs, "user", "u")
+	if len(options.identities) == 0 {
+		options.identities = pssh.ToSlice(defaultIdentityFiles)
+	}
+	entries, _, err := loadTargets(ctx, &options, stdin)
+	if err == nil {
+		entries, _, err = selectEntries(entries, options.selects)
+	}
+	if err == nil {
+		err = resolveTargets(entries, options)
+	}
+	if err != nil {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"hosts_file_invalid", err.Error(), path, options.hostsFile, nil, knownHostsScanUsage(),
+		))
+	}
+	if len(entries) == 0 {
+		return renderUsageError(stdout, stderr, jsonMode, newUsageError(
+			"missing_argument", "at least one --hosts-file or --host target is required", path, "", nil, knownHostsScanUsage(),
+		))
+	}
+	closePrompt, secretErr := loadSecrets(&options, true)
+	if secretErr != nil {
+		return renderCommandError(stdout, stderr, jsonMode, secretErr)
+	}
+	defer closePrompt()
+	options.config.IdentFiles = options.identities
+	options.config.Stderr = stderr
+	engine := &pssh.Pssh{Config: &options.config}
+	scans, err := engine.ScanHostKeys(ctx, entryHosts(entries), scanTypes)
+	if err != nil {
+		return invalid(err, "")
+	}
+	compare := diff || write
+	summary := scanSummary{}
+	if compare {
+		if err := pssh.CompareHostKeys(&options.config, scans); err != nil {
+			return renderCommandError(stdout, stderr, jsonMode, &commandError{
+				Code: "known_hosts_invalid", Message: err.Error(),
+				Details: map[string]any{"paths": options.config.EffectiveKnownHostsFiles()},
+			})
+		}
+	}
+	if write {
+		if summary.Written, err = pssh.AppendHostKeys(&options.config, scans, hash); err != nil {
+			return renderCommandError(stdout, stderr, jsonMode, &commandError{
+				Code: "known_hosts_write_failed", Message: err.Error(),
+				Details: map[string]any{"path": options.config.EffectiveKnownHostsFiles()[0]},
+			})
+		}
+	}
+	hosts := scannedHosts(scans, hash, &summary)
+	ok := summary.Failed == 0 && summary.Changed == 0
+	if jsonMode {
+		payload := map[string]any{
+			"schema_version": schemaVersion, "command": "known-hosts scan", "ok": ok,
+			"hosts": hosts, "summary": summary,
+		}
+		if err := json.NewEncoder(stdout).Encode(payload); err != nil {
+			return 1
+		}
+	} else if err := writeScannedHosts(stdout, stderr, hosts, compare); err != nil {
+		return 1
+	} else if compare {
+		if _, err := fmt.Fprintf(stderr, "scanned=%d failed=%d new=%d known=%d changed=%d written=%d\n",
+			summary.Scanned, summary.Failed, summary.New, summary.Known, summary.Changed, summary.Written); err != nil {
+			return 1
+		}
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func scannedHosts(scans []pssh.HostKeyScan, hash bool, summary *scanSummary) []scannedHost {
+	hosts := make([]scannedHost, len(scans))
+	for i, scan := range scans {
+		hosts[i] = scannedHost{Target: scan.Target, Address: scan.Addr, Keys: []scannedKey{}, Error: errorString(scan.Err, "")}
+		summary.Scanned++
+		if scan.Err != nil {
+			summary.Failed++
+		}
+		for _, scanned := range scan.Keys {
+			key := scannedKey{
+				Type: scanned.Key.Type(), Fingerprint: ssh.FingerprintSHA256(scanned.Key),
+				KnownHosts: scan.KnownHostsLine(scanned.Key, hash), Status: scanned.Status,
+			}
+			for _, recorded := range scanned.Recorded {
+				key.Recorded = append(key.Recorded, fmt.Sprintf("%s:%d %s", recorded.Filename, recorded.Line, ssh.FingerprintSHA256(recorded.Key)))
+			}
+			switch scanned.Status {
+			case pssh.HostKeyNew:
+				summary.New++
+			case pssh.HostKeyKnown:
+				summary.Known++
+			case pssh.HostKeyChanged:
+				summary.Changed++
+			}
+			hosts[i].Keys = append(hosts[i].Keys, key)
+		}
+	}
+	return hosts
+}
+
+// writeScannedHosts prints known_hosts lines, or with compare the lines of
+// new keys prefixed "+ " and of changed keys prefixed "! ". Failures and
+// the recorded keys of changed ones go to stderr.
+func writeScannedHosts(stdout, stderr io.Writer, hosts []scannedHost, compare bool) error {
+	for _, host := range hosts {
+		if host.Error != "" {
+			if _, err := fmt.Fprintf(stderr, "%s: %s\n", host.Target, host.Error); err != nil {
+				return err
+			}
+		}
+		for _, key := range host.Keys {
+			prefix := ""
+			if compare {
+				switch key.Status {
+				case pssh.HostKeyKnown:
+					continue
+				case pssh.HostKeyNew:
+					prefix = "+ "
+				case pssh.HostKeyChanged:
+					prefix = "! "
+					if _, err := fmt.Fprintf(stderr, "%s: %s key changed to %s; known_hosts has %s\n",
+						host.Target, key.Type, key.Fingerprint, strings.Join(key.Recorded, ", ")); err != nil {
+						return err
+					}
+				}
+			}
+			if _, err := fmt.Fprintln(stdout, prefix+key.KnownHosts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func knownHostsHelpText() string {
+	return `Collect and pin SSH host keys.
+
+Usage:
+  gopssh known-hosts <command> [options]
+
+Commands:
+  scan       Fetch the host keys of targets without authenticating
+
+Examples:
+  gopssh known-hosts scan --hosts-file hosts.txt >> ~/.ssh/known_hosts
+  gopssh known-hosts scan --hosts-file hosts.txt --diff
+`
+}
+
+func knownHostsScanHelpText() string {
+	return `Fetch the host keys of every target, --parallel at a time, and print them
+as known_hosts lines. Only the key exchange runs; targets are not
+authenticated. Jump hosts are verified and authenticated as for run.
+
+Usage:
+  gopssh known-hosts scan [options]
+
+Options:
+  -H, --hosts-file PATH[:GROUP]
+      --hosts-exec COMMAND
+      --host [USER@]HOST[:PORT]  Repeatable
+      --group NAME            Repeatable inventory group
+      --select SELECTOR
+      --exclude HOST[:PORT]   Repeatable
+      --exclude-file PATH
+  -u, --user USER             SSH user for jump hosts and proxy commands
+  -p, --parallel N            Concurrent scans (default: 32)
+  -i, --identity PATH         Repeatable identity for jump hosts
+      --identities-only
+      --identity-passphrase-file PATH
+      --connect-timeout DURATION (default: 15s)
+      --type LIST             Key types to fetch (default: ed25519,ecdsa,rsa)
+      --hash                  Hash host names as ssh-keygen -H does
+      --diff                  Compare with known_hosts: print new keys as
+                              '+ LINE' and changed keys as '! LINE'; a changed
+                              key fails the scan
+      --write                 Like --diff, and append the new keys to the first
+                              --known-hosts file under a lock; changed keys are
+                              never written
+      --known-hosts FILE      Repeatable known_hosts file (default: ~/.ssh/known_hosts)
+      --host-key-policy strict|accept-new|insecure  Verification of jump hosts
+      --host-ca-file PATH     Repeatable trusted host CA keys for jump hosts
+      --insecure-ignore-host-key
+      --ssh-config PATH       Resolve Host aliases through PATH (default: ~/.ssh/config)
+      --no-ssh-config
+  -J, --jump [USER@]HOST[:PORT]  Repeatable bastion chain
+      --proxy-command COMMAND
+      --proxy URL             SOCKS5 or HTTP CONNECT proxy (default: $ALL_PROXY
+                              except for $NO_PROXY)
+      --json                  Emit the keys with SHA256 fingerprints as JSON
+  -h, --help
+
+Examples:
+  gopssh known-hosts scan --hosts-file hosts.txt --hash >> ~/.ssh/known_hosts
+  gopssh known-hosts scan --hosts-file hosts.txt --jump bastion --diff
+  gopssh known-hosts scan --hosts-file hosts.txt --write --hash
+`
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+// startHostKeyServer returns the address of an SSH server presenting the
+// host keys named from testdata.
+func startHostKeyServer(t *testing.T, names ...string) string {
+	t.Helper()
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	for _, name := range names {
+		signer, err := ssh.ParsePrivateKey(testdata.PEMBytes[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.AddHostKey(signer)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				if server, _, _, err := ssh.NewServerConn(conn, config); err == nil {
+					_ = server.Close()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func testPublicKey(t *testing.T, name string) ssh.PublicKey {
+	t.Helper()
+	signer, err := ssh.ParsePrivateKey(testdata.PEMBytes[name])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey()
+}
+
+func TestKnownHostsScan(t *testing.T) {
+	addr := startHostKeyServer(t, "ed25519", "rsa")
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	scan := func(args ...string) (int, string, string) {
+		t.Helper()
+		args = append([]string{"known-hosts", "scan", "--no-ssh-config", "--identities-only",
+			"--host", addr, "--known-hosts", knownHostsFile}, args...)
+		return executeForTest(t, args...)
+	}
+
+	code, stdout, stderr := scan()
+	name := knownhosts.Normalize(addr)
+	if code != 0 || stderr != "" || stdout != knownhosts.Line([]string{name}, testPublicKey(t, "ed25519"))+"\n"+
+		knownhosts.Line([]string{name}, testPublicKey(t, "rsa"))+"\n" {
+		t.Fatalf("code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+
+	if err := os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{name}, testPublicKey(t, "ed25519"))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, _ = scan("--json", "--diff")
+	var payload struct {
+		OK      bool          `json:"ok"`
+		Hosts   []scannedHost `json:"hosts"`
+		Summary scanSummary   `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &payload); err != nil {
+		t.Fatalf("stdout=%q: %v", stdout, err)
+	}
+	if code != 0 || !payload.OK || len(payload.Hosts) != 1 || len(payload.Hosts[0].Keys) != 2 ||
+		payload.Hosts[0].Keys[0].Status != "known" || payload.Hosts[0].Keys[1].Status != "new" ||
+		payload.Hosts[0].Keys[1].Fingerprint != ssh.FingerprintSHA256(testPublicKey(t, "rsa")) ||
+		payload.Summary != (scanSummary{Scanned: 1, Known: 1, New: 1}) {
+		t.Fatalf("code=%d payload=%+v", code, payload)
+	}
+
+	code, stdout, stderr = scan("--write", "--hash")
+	if code != 0 || !strings.HasPrefix(stdout, "+ |1|") || strings.Count(stdout, "\n") != 1 || !strings.Contains(stderr, "written=1") {
+		t.Fatalf("code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+	if code, stdout, _ = scan("--diff"); code != 0 || stdout != "" {
+		t.Fatalf("after --write: code=%d stdout=%q", code, stdout)
+	}
+
+	if err := os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{name}, testPublicKey(t, "ca"))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr = scan("--write")
+	if code != 1 || !strings.Contains(stdout, "! "+name+" ssh-rsa ") || !strings.Contains(stderr, "ssh-rsa key changed to SHA256:") {
+		t.Fatalf("changed key: code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+	if data, _ := os.ReadFile(knownHostsFile); strings.Count(string(data), "\n") != 2 {
+		t.Fatalf("known_hosts = %q", data)
+	}
+
+	if code, _, stderr := scan("--type", "dsa"); code != paramErrCode || !strings.Contains(stderr, "--type") {
+		t.Fatalf("--type dsa: code=%d stderr=%q", code, stderr)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	_ = closed.Close()
+	code, stdout, stderr = scan("--host", closedAddr)
+	if code != 1 || strings.Count(stdout, "\n") != 2 || !strings.HasPrefix(stderr, closedAddr+": ") {
+		t.Fatalf("unreachable host: code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+}
@@ -23,6 +23,7 @@ const (
 	// https://man.openbsd.org/ssh_config#IdentityFile
 	defaultIdentityFiles = "~/.ssh/id_dsa,~/.ssh/id_ecdsa,~/.ssh/id_ed25519,~/.ssh/id_rsa"
 	defaultTimeout       = 15 * time.Second
+	defaultGracePeriod   = 5 * time.Second
 	paramErrCode         = 2
 )
 
@@ -123,6 +124,7 @@ func defaultConfig() pssh.Config {
 		Debug:           false,
 		SortPrint:       true,
 		Timeout:         defaultTimeout,
+		GracePeriod:     defaultGracePeriod,
 		SSHAuthSocket:   os.Getenv("SSH_AUTH_SOCK"),
 	}
 }
@@ -231,15 +233,11 @@ func main() {
 		signalChannel := make(chan os.Signal, 1)
 		signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 		defer signal.Stop(signalChannel)
-		go func() {
-			select {
-			case received := <-signalChannel:
-				cancel(fmt.Errorf("received signal: %s", received))
-			case <-ctx.Done():
-			}
-		}()
+		done := make(chan struct{})
+		go forwardSignals(signalChannel, done, cancel, func() { signal.Stop(signalChannel) }, os.Stderr)
 		code := executeModern(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
 		code = signalExitCode(code, context.Cause(ctx))
+		close(done)
 		cancel(nil)
 		os.Exit(code)
 	}
@@ -259,6 +257,34 @@ func main() {
 	os.Exit(code)
 }
 
+// forwardSignals cancels the run on the first signal so that the remote
+// commands are sent the same signal, and closes their sessions without
+// waiting for the grace period on the second. It then calls stop, so that a
+// third signal kills gopssh should it still not exit.
+func forwardSignals(
+	signals <-chan os.Signal, done <-chan struct{}, cancel context.CancelCauseFunc, stop func(), stderr io.Writer,
+) {
+	force := make(chan struct{})
+	select {
+	case received := <-signals:
+		remote := ssh.SIGINT
+		if received == syscall.SIGTERM {
+			remote = ssh.SIGTERM
+		}
+		cancel(&pssh.SignalCause{Name: received.String(), Signal: remote, Force: force})
+		// nolint: errcheck
+		fmt.Fprintf(stderr, "gopssh: sent SIG%s to the remote commands; signal again to close them now\n", remote)
+	case <-done:
+		return
+	}
+	select {
+	case <-signals:
+		close(force)
+		stop()
+	case <-done:
+	}
+}
+
 func signalExitCode(code int, cause error) int {
 	if cause == nil {
 		return code
@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"os"
 	"reflect"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/masahide/gopssh/pkg/pssh"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -156,6 +159,41 @@ func TestSignalExitCode(t *testing.T) {
 	}
 }
 
+func TestForwardSignals(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	defer close(done)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var stderr bytes.Buffer
+	stopped := make(chan struct{})
+	go forwardSignals(signals, done, cancel, func() { close(stopped) }, &stderr)
+	signals <- syscall.SIGTERM
+	<-ctx.Done()
+	var cause *pssh.SignalCause
+	if !errors.As(context.Cause(ctx), &cause) || cause.Signal != ssh.SIGTERM || signalExitCode(1, cause) != 143 {
+		t.Fatalf("cause=%v", context.Cause(ctx))
+	}
+	select {
+	case <-cause.Force:
+		t.Fatal("forced after one signal")
+	default:
+	}
+	signals <- os.Interrupt
+	select {
+	case <-cause.Force:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not forced after a second signal")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signals still caught after forcing")
+	}
+	if stderr.String() != "gopssh: sent SIGTERM to the remote commands; signal again to close them now\n" {
+		t.Fatalf("stderr=%q", stderr.String())
+	}
+}
+
 func TestLegacyParserStopsAtCommandAndPreservesBooleanValues(t *testing.T) {
 	oldArgs := os.Args
 	oldCommandLine := flag.CommandLine
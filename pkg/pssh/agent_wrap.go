@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cenkalti/backoff"
 	"golang.org/x/crypto/ssh"
@@ -23,6 +25,39 @@ type connPools struct {
 	limit     chan struct{}
 	connPool  sync.Pool
 	netDialer dialIface
+	stats     agentStats
+}
+
+// agentStats counts the agent requests of one run.
+type agentStats struct {
+	lists, signs, errors atomic.Int64
+	wait                 atomic.Int64 // nanoseconds
+}
+
+// AgentStats counts the SSH Agent requests of a run, including those of
+// forwarded agents.
+type AgentStats struct {
+	Lists  int64
+	Signs  int64
+	Errors int64
+	// Wait is the total time spent waiting for one of the MaxAgentConns
+	// agent connections.
+	Wait time.Duration
+}
+
+func (s *agentStats) snapshot() AgentStats {
+	return AgentStats{
+		Lists: s.lists.Load(), Signs: s.signs.Load(), Errors: s.errors.Load(),
+		Wait: time.Duration(s.wait.Load()),
+	}
+}
+
+// count records the outcome of one agent request.
+func (s *agentStats) count(counter *atomic.Int64, err error) {
+	counter.Add(one)
+	if err != nil {
+		s.errors.Add(one)
+	}
 }
 
 type dialIface interface {
@@ -58,7 +93,9 @@ func (cp *connPools) newKeyAgent() (*keyAgent, error) {
 }
 
 func (cp *connPools) Get() (*keyAgent, error) {
+	start := time.Now()
 	cp.limit <- struct{}{} // 空くまで待つ
+	cp.stats.wait.Add(int64(time.Since(start)))
 	if pooled := cp.connPool.Get(); pooled != nil {
 		return pooled.(*keyAgent), nil
 	}
@@ -95,15 +132,28 @@ func (cp *connPools) dialSocket() (net.Conn, error) {
 	return authConn, err
 }
 
+// agentSignersTTL is how long the key list of the agent is trusted before
+// Signers lists the keys again; errors are cached as long.
+const agentSignersTTL = 30 * time.Second
+
+// agentClient is the agent of one run, shared by every target, jump host and
+// forwarded agent.
 type agentClient struct {
 	*connPools
 
-	signers []ssh.Signer
-	mu      sync.RWMutex
+	ttl time.Duration
+	now func() time.Time
+
+	mu         sync.Mutex
+	signers    []ssh.Signer
+	signersErr error
+	listed     time.Time
+	// refreshing is closed when the List in flight completes.
+	refreshing chan struct{}
 }
 
-func newAgentClient(cp *connPools) agent.ExtendedAgent {
-	return &agentClient{connPools: cp}
+func newAgentClient(cp *connPools) *agentClient {
+	return &agentClient{connPools: cp, ttl: agentSignersTTL, now: time.Now}
 }
 
 func (c *agentClient) RemoveAll() error {
@@ -123,7 +173,8 @@ func (c *agentClient) Unlock(passphrase []byte) error {
 }
 
 // List returns the identities known to the agent.
-func (c *agentClient) List() ([]*agent.Key, error) {
+func (c *agentClient) List() (keys []*agent.Key, err error) {
+	defer func() { c.stats.count(&c.stats.lists, err) }()
 	ka, err := c.Get()
 	if err != nil {
 		return nil, err
@@ -134,7 +185,8 @@ func (c *agentClient) List() ([]*agent.Key, error) {
 
 // Sign has the agent sign the data using a protocol 2 key as defined
 // in [PROTOCOL.agent] section 2.6.2.
-func (c *agentClient) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+func (c *agentClient) Sign(key ssh.PublicKey, data []byte) (signature *ssh.Signature, err error) {
+	defer func() { c.stats.count(&c.stats.signs, err) }()
 	ka, err := c.Get()
 	if err != nil {
 		return nil, err
@@ -143,7 +195,8 @@ func (c *agentClient) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, erro
 	return ka.Sign(key, data)
 }
 
-func (c *agentClient) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+func (c *agentClient) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (signature *ssh.Signature, err error) {
+	defer func() { c.stats.count(&c.stats.signs, err) }()
 	ka, err := c.Get()
 	if err != nil {
 		return nil, err
@@ -158,37 +211,97 @@ func (c *agentClient) Add(key agent.AddedKey) error {
 	return errors.New("not implemented agentClient Add")
 }
 
-// Signers provides a callback for agentClient authentication.
+// Signers provides a callback for agentClient authentication. The key list,
+// or the error listing it, is cached for ttl; when it expires one caller
+// lists the keys again while the others wait for its result.
 func (c *agentClient) Signers() ([]ssh.Signer, error) {
-	c.mu.RLock()
-	res := c.signers
-	c.mu.RUnlock()
-	if res != nil {
-		return res, nil
+	c.mu.Lock()
+	for c.refreshing != nil {
+		refreshing := c.refreshing
+		c.mu.Unlock()
+		<-refreshing
+		c.mu.Lock()
 	}
+	if c.listed.IsZero() || c.now().Sub(c.listed) >= c.ttl {
+		return c.refreshSigners()
+	}
+	defer c.mu.Unlock()
+	return c.signers, c.signersErr
+}
+
+// refreshSigners lists the keys of the agent. It is called with c.mu held
+// and releases it.
+func (c *agentClient) refreshSigners() ([]ssh.Signer, error) {
+	refreshing := make(chan struct{})
+	c.refreshing = refreshing
+	c.mu.Unlock()
 	keys, err := c.List()
-	if err != nil {
-		return nil, err
+	var signers []ssh.Signer
+	if err == nil {
+		signers = make([]ssh.Signer, len(keys))
+		for i, k := range keys {
+			signers[i] = &agentKeyringSigner{c, agentPublicKey(k)}
+		}
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	result := make([]ssh.Signer, len(keys))
-	for i, k := range keys {
-		result[i] = &agentKeyringSigner{c, k}
+	c.signers, c.signersErr, c.listed = signers, err, c.now()
+	c.refreshing = nil
+	close(refreshing)
+	return signers, err
+}
+
+// invalidateSigners makes the next Signers list the keys again, after a
+// key has been removed from the agent.
+func (c *agentClient) invalidateSigners() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing == nil {
+		c.listed = time.Time{}
 	}
-	c.signers = result
-	return result, nil
+}
+
+// Stats returns the requests made so far.
+func (c *agentClient) Stats() AgentStats {
+	return c.stats.snapshot()
 }
 
 func (c *agentClient) Extension(extensionType string, contents []byte) ([]byte, error) {
 	ka, err := c.Get()
 	if err != nil {
+		c.stats.errors.Add(one)
 		return nil, err
 	}
 	defer c.Put(ka)
 	return ka.Extension(extensionType, contents)
 }
 
+// forwardAgent serves the auth-agent@openssh.com channels that the server
+// of conn opens for sessions that requested agent forwarding, like
+// agent.ForwardToAgent. Every request goes through keyring.
+func forwardAgent(conn sshClientIface, keyring agent.Agent) error {
+	channels := conn.HandleChannelOpen(agentForwardChannel)
+	if channels == nil {
+		return errors.New("agent forwarding is already set up")
+	}
+	go func() {
+		for newChannel := range channels {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				_ = agent.ServeAgent(keyring, channel)
+				_ = channel.Close()
+			}()
+		}
+	}()
+	return nil
+}
+
+const agentForwardChannel = "auth-agent@openssh.com"
+
 type agentKeyringSigner struct {
 	agent *agentClient
 	pub   ssh.PublicKey
@@ -200,7 +313,38 @@ func (s *agentKeyringSigner) PublicKey() ssh.PublicKey {
 
 func (s *agentKeyringSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
 	// The agent has its own entropy source, so the rand argument is ignored.
-	return s.agent.Sign(s.pub, data)
+	return s.signed(s.agent.Sign(s.pub, data))
+}
+
+// signed forgets the cached key list when a signature fails, in case the
+// key has left the agent.
+func (s *agentKeyringSigner) signed(signature *ssh.Signature, err error) (*ssh.Signature, error) {
+	if err != nil {
+		s.agent.invalidateSigners()
+	}
+	return signature, err
+}
+
+// SignWithAlgorithm lets RSA keys and certificates sign with SHA-2, which
+// current servers require.
+func (s *agentKeyringSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return s.signed(s.agent.SignWithFlags(s.pub, data, flags))
+}
+
+// agentPublicKey parses an agent key so that certificates held by the agent
+// are presented as *ssh.Certificate.
+func agentPublicKey(key *agent.Key) ssh.PublicKey {
+	if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+		return pub
+	}
+	return key
 }
 
 func (s *agentKeyringSigner) SignWithOpts(rand io.Reader, data []byte, opts crypto.SignerOpts) (*ssh.Signature, error) {
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -304,3 +307,125 @@ func TestSigners(t *testing.T) {
 		t.Errorf("list:%q", list)
 	}
 }
+
+// serveTestAgent serves keyring on a unix socket and returns its path.
+func serveTestAgent(t *testing.T, keyring agent.Agent) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestForwardAgent(t *testing.T) {
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: testPrivateKeys["ed25519"]}); err != nil {
+		t.Fatal(err)
+	}
+	addr := startSessionServer(t, func(s *testSession) int {
+		if !s.agentRequest {
+			_, _ = fmt.Fprintln(s.channel, "no agent request")
+			return 2
+		}
+		channel, requests, err := s.conn.OpenChannel(agentForwardChannel, nil)
+		if err != nil {
+			_, _ = fmt.Fprintln(s.channel, err)
+			return 1
+		}
+		go ssh.DiscardRequests(requests)
+		defer func() {
+			_ = channel.Close()
+		}()
+		keys, err := agent.NewClient(channel).List()
+		if err != nil {
+			_, _ = fmt.Fprintln(s.channel, err)
+			return 1
+		}
+		_, _ = fmt.Fprintf(s.channel, "%d %s\n", len(keys), keys[0].Type())
+		return 0
+	})
+	var got strings.Builder
+	var result Result
+	p := &Pssh{Config: &Config{
+		Concurrency: 1, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+		SSHAuthSocket: serveTestAgent(t, keyring), ForwardAgent: true, IgnoreHostKey: true, Timeout: 5 * time.Second,
+		Hosts: []Host{{Target: addr}}, Command: "ssh-add -l", Stdout: io.Discard, Stderr: io.Discard,
+		ResultHandler: func(r *Result) error {
+			result = *r
+			_, err := r.Stdout.WriteTo(&got)
+			return err
+		},
+	}}
+	p.Init()
+	if code := p.Run(); code != 0 || result.Kind != ResultSuccess || got.String() != "1 ssh-ed25519\n" {
+		t.Fatalf("code=%d result=%+v stdout=%q", code, result, got.String())
+	}
+}
+
+func TestAgentClientCachesSigners(t *testing.T) {
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: testPrivateKeys["ed25519"]}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	ac := newAgentClient(newConnPools(serveTestAgent(t, keyring), 2))
+	ac.now = func() time.Time { return now }
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			if signers, err := ac.Signers(); err != nil || len(signers) != 1 {
+				t.Errorf("signers=%v err=%v", signers, err)
+			}
+		})
+	}
+	wg.Wait()
+	if stats := ac.Stats(); stats.Lists != 1 || stats.Errors != 0 {
+		t.Fatalf("concurrent Signers stats = %+v", stats)
+	}
+	now = now.Add(agentSignersTTL)
+	signers, err := ac.Signers()
+	if err != nil || ac.Stats().Lists != 2 {
+		t.Fatalf("after ttl: err=%v stats=%+v", err, ac.Stats())
+	}
+
+	// A key removed from the agent fails to sign and is listed again.
+	if err := keyring.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signers[0].Sign(nil, []byte("data")); err == nil {
+		t.Fatal("removed key signed")
+	}
+	if signers, err := ac.Signers(); err != nil || len(signers) != 0 {
+		t.Fatalf("after removal: signers=%v err=%v", signers, err)
+	}
+	if stats := ac.Stats(); stats.Lists != 3 || stats.Signs != 1 || stats.Errors != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	broken := newAgentClient(newConnPools(filepath.Join(t.TempDir(), "missing.sock"), 1))
+	for range 3 {
+		if _, err := broken.Signers(); err == nil {
+			t.Fatal("missing agent listed keys")
+		}
+	}
+	if stats := broken.Stats(); stats.Lists != 1 || stats.Errors != 1 {
+		t.Fatalf("missing agent stats = %+v", stats)
+	}
+}
@@ -0,0 +1,196 @@
+package pssh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Threshold is a number of targets, or a percentage of all targets when
+// Percent is set.
+type Threshold struct {
+	Value   int
+	Percent bool
+}
+
+// ParseThreshold parses N or P% with P at most 100.
+func ParseThreshold(value string) (Threshold, error) {
+	number, percent := strings.CutSuffix(value, "%")
+	n, err := strconv.Atoi(number)
+	switch {
+	case err != nil || n < 0:
+		return Threshold{}, fmt.Errorf("%q is not a number or percentage", value)
+	case percent && n > 100:
+		return Threshold{}, fmt.Errorf("%q is more than 100%%", value)
+	}
+	return Threshold{Value: n, Percent: percent}, nil
+}
+
+func (t Threshold) String() string {
+	if t.Percent {
+		return strconv.Itoa(t.Value) + "%"
+	}
+	return strconv.Itoa(t.Value)
+}
+
+// Of returns the number of targets out of total, rounding percentages up.
+func (t Threshold) Of(total int) int {
+	if t.Percent {
+		return (total*t.Value + 99) / 100
+	}
+	return t.Value
+}
+
+// Exceeded reports whether failed targets out of total are more than t.
+func (t Threshold) Exceeded(failed, total int) bool {
+	if t.Percent {
+		return failed*100 > t.Value*total
+	}
+	return failed > t.Value
+}
+
+// BatchStats describes one batch of a run with Config.BatchSize.
+type BatchStats struct {
+	// Targets is the number of targets started in the batch.
+	Targets int
+	// Failed is the number of those with a failed result.
+	Failed   int
+	Duration time.Duration
+}
+
+// Batches returns the batches of the last run, in order; it is empty
+// without Config.BatchSize. Batches never started after Config.MaxFail was
+// exceeded are not included.
+func (p *Pssh) Batches() []BatchStats {
+	return p.batches
+}
+
+// MaxFailExceeded reports whether the last run stopped starting targets
+// because more than Config.MaxFail of them failed.
+func (p *Pssh) MaxFailExceeded() bool {
+	return p.maxFailExceeded
+}
+
+// batchSize is the number of targets started together, all of them
+// without Config.BatchSize.
+func (p *Pssh) batchSize() int {
+	if p.BatchSize.Value == 0 {
+		return len(p.cws)
+	}
+	return max(one, p.BatchSize.Of(len(p.cws)))
+}
+
+func (p *Pssh) failureLimitExceeded() bool {
+	return p.MaxFail != nil && p.MaxFail.Exceeded(int(p.failedTargets.Load()), len(p.cws))
+}
+
+// launchConWorkers starts the canaries first, then the other workers in
+// batches of batchSize, Concurrency at a time. A batch finishes before the
+// next one starts, BatchPause later or at the run Deadline, whichever comes
+// first. Once more than MaxFail targets have failed, the workers not yet
+// started report every step as canceled. It returns the number of workers
+// started.
+func (p *Pssh) launchConWorkers(ctx context.Context) int {
+	order := p.launchOrder()
+	start := len(p.Canaries)
+	if start > 0 {
+		if started, ok := p.runCanaries(ctx, order); !ok {
+			return started
+		}
+	}
+	size := p.batchSize()
+	for ; start < len(order); start += size {
+		if start > len(p.Canaries) && !p.failureLimitExceeded() && p.BatchPause > 0 {
+			pause := time.NewTimer(p.BatchPause)
+			expired, stop := p.deadlineTimer()
+			select {
+			case <-pause.C:
+			case <-expired:
+			case <-ctx.Done():
+			}
+			pause.Stop()
+			stop()
+		}
+		end := min(start+size, len(order))
+		began := time.Now()
+		batch, started := p.startWorkers(ctx, order[start:], end-start)
+		if started < end-start {
+			return start + started
+		}
+		if size == len(p.cws) {
+			continue
+		}
+		batch.Wait()
+		stats := BatchStats{Targets: end - start, Duration: time.Since(began)}
+		for _, cw := range order[start:end] {
+			if cw.anyFailed {
+				stats.Failed++
+			}
+		}
+		p.batches = append(p.batches, stats)
+	}
+	return len(order)
+}
+
+// launchOrder is the order the workers start in, set by prepareCanaries.
+func (p *Pssh) launchOrder() []*conWork {
+	if p.order == nil {
+		return p.cws
+	}
+	return p.order
+}
+
+// startWorkers starts the first n workers of rest and returns how many it
+// started. When the run is canceled or MaxFail is exceeded first, it ends
+// the workers not started.
+func (p *Pssh) startWorkers(ctx context.Context, rest []*conWork, n int) (*sync.WaitGroup, int) {
+	batch := &sync.WaitGroup{}
+	for i, cw := range rest[:n] {
+		if ctx.Err() != nil {
+			p.finishUnlaunchedWorkers(rest[i:])
+			return batch, i
+		}
+		if p.failureLimitExceeded() {
+			p.maxFailExceeded = true
+			p.endUnlaunchedWorkers(ctx, rest[i:], ResultCanceled, fmt.Errorf("not started: %d targets failed, more than the %s allowed",
+				p.failedTargets.Load(), p.MaxFail))
+			return batch, i
+		}
+		if p.Concurrency > 0 {
+			select {
+			case p.concurrentGoroutines <- struct{}{}:
+			case <-ctx.Done():
+				p.finishUnlaunchedWorkers(rest[i:])
+				return batch, i
+			}
+		}
+		batch.Add(one)
+		go func() {
+			defer p.workerWG.Done()
+			defer batch.Done()
+			if p.Concurrency > 0 {
+				defer func() { <-p.concurrentGoroutines }()
+			}
+			cw.conWorker(ctx, p.clientConf)
+		}()
+	}
+	return batch, n
+}
+
+func (p *Pssh) finishUnlaunchedWorkers(rest []*conWork) {
+	for range rest {
+		p.workerWG.Done()
+	}
+}
+
+// endUnlaunchedWorkers reports the steps of the workers not started as kind
+// with err.
+func (p *Pssh) endUnlaunchedWorkers(ctx context.Context, rest []*conWork, kind ResultKind, err error) {
+	for _, cw := range rest {
+		cw.endSteps(ctx, kind, err)
+		p.workerWG.Done()
+	}
+}
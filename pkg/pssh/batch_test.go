@@ -0,0 +1,89 @@
+package pssh
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	for value, want := range map[string]Threshold{
+		"5":    {Value: 5},
+		"0":    {},
+		"10%":  {Value: 10, Percent: true},
+		"100%": {Value: 100, Percent: true},
+	} {
+		if got, err := ParseThreshold(value); err != nil || got != want || got.String() != value {
+			t.Errorf("ParseThreshold(%q) = %+v, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"", "-1", "ten", "101%", "5%%"} {
+		if _, err := ParseThreshold(value); err == nil {
+			t.Errorf("ParseThreshold(%q) succeeded", value)
+		}
+	}
+	if got := (Threshold{Value: 10, Percent: true}).Of(25); got != 3 {
+		t.Fatalf("10%% of 25 = %d", got)
+	}
+	tenPercent := Threshold{Value: 10, Percent: true}
+	if tenPercent.Exceeded(2, 20) || !tenPercent.Exceeded(3, 20) || (Threshold{Value: 1}).Exceeded(1, 20) {
+		t.Fatal("Exceeded")
+	}
+}
+
+func TestRunBatches(t *testing.T) {
+	var mu sync.Mutex
+	arrived, active, peak := 0, 0, 0
+	// The two targets of a batch wait for each other, so that they run at
+	// once however long their handshakes take.
+	barriers := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+	handle := func(code int) func(*testSession) int {
+		return func(*testSession) int {
+			mu.Lock()
+			barrier := barriers[arrived/2]
+			arrived++
+			active++
+			peak = max(peak, active)
+			if arrived%2 == 0 {
+				close(barrier)
+			}
+			mu.Unlock()
+			select {
+			case <-barrier:
+			case <-time.After(5 * time.Second):
+			}
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return code
+		}
+	}
+	good, bad := startSessionServer(t, handle(0)), startSessionServer(t, handle(1))
+
+	var got []string
+	p := &Pssh{Config: &Config{
+		Concurrency: 5, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+		IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+		Hosts:     []Host{{Target: good}, {Target: good}, {Target: bad}, {Target: good}, {Target: good}},
+		BatchSize: Threshold{Value: 40, Percent: true}, BatchPause: 50 * time.Millisecond, MaxFail: &Threshold{},
+		Command: "deploy", Stdout: io.Discard, Stderr: io.Discard,
+		ResultHandler: func(r *Result) error {
+			got = append(got, fmt.Sprintf("%d %s %d", r.Index, r.Kind, r.ExitCode))
+			return nil
+		},
+	}}
+	p.Init()
+	started := time.Now()
+	code := p.Run()
+	want := []string{"0 success 0", "1 success 0", "2 remote_exit 1", "3 success 0", "4 canceled 1"}
+	if code != 1 || !reflect.DeepEqual(got, want) || peak != 2 || time.Since(started) < p.BatchPause {
+		t.Fatalf("code=%d peak=%d results=%q", code, peak, got)
+	}
+	batches := p.Batches()
+	if len(batches) != 2 || batches[0].Targets != 2 || batches[0].Failed != 0 || batches[1].Failed != 1 || !p.MaxFailExceeded() {
+		t.Fatalf("batches=%+v exceeded=%t", batches, p.MaxFailExceeded())
+	}
+}
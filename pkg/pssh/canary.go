@@ -0,0 +1,147 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CanaryStats describes the canaries of a run with Config.Canaries.
+type CanaryStats struct {
+	// Targets is the number of canaries, and Failed the number of those
+	// with a failed result.
+	Targets int
+	Failed  int
+	// Passed reports whether the canary results give exit code 0 under
+	// Config.ExitPolicy.
+	Passed bool
+	// Continued reports whether the other targets were started.
+	Continued bool
+	Duration  time.Duration
+}
+
+// Canary returns the canaries of the last run; it is the zero value without
+// Config.Canaries.
+func (p *Pssh) Canary() CanaryStats {
+	return p.canary
+}
+
+// prepareCanaries marks the canaries and orders the workers so that they
+// start, and with SortPrint are reported, before the other targets.
+func (p *Pssh) prepareCanaries() error {
+	p.canary, p.canaryCode = CanaryStats{}, 0
+	p.canaryPending = len(p.Canaries) * p.stepCount()
+	p.canaryEmitted = make(chan struct{})
+	if p.canaryPending == 0 {
+		close(p.canaryEmitted)
+	}
+	for _, i := range p.Canaries {
+		if i < 0 || i >= len(p.cws) {
+			return fmt.Errorf("canary %d is not a target", i)
+		}
+		if p.cws[i].canary {
+			return fmt.Errorf("canary %d is given twice", i)
+		}
+		p.cws[i].canary = true
+	}
+	p.order = make([]*conWork, 0, len(p.cws))
+	for _, i := range p.Canaries {
+		p.order = append(p.order, p.cws[i])
+	}
+	for _, cw := range p.cws {
+		if !cw.canary {
+			p.order = append(p.order, cw)
+		}
+	}
+	for pos, cw := range p.order {
+		cw.pos = pos
+	}
+	return nil
+}
+
+// outputPos is the place of target conID in input order output, which is
+// its place in the launch order.
+func (p *Pssh) outputPos(cws []*conWork, conID int) int {
+	if p.order == nil {
+		return conID
+	}
+	return cws[conID].pos
+}
+
+// canaryEmit notes an emitted result; once every canary result has been
+// emitted, the canaries can be judged. It runs on the output goroutine.
+func (p *Pssh) canaryEmit(res *result) {
+	if p.canaryPending == 0 || !res.canary {
+		return
+	}
+	p.canaryCode = p.aggregateCode(p.canaryCode, res.code)
+	if p.canaryPending--; p.canaryPending == 0 {
+		close(p.canaryEmitted)
+	}
+}
+
+// runCanaries starts the canaries at the front of order and waits until
+// their results have been emitted. It returns the number of workers started
+// and whether the other targets may start; those that may not have been
+// reported as canceled.
+func (p *Pssh) runCanaries(ctx context.Context, order []*conWork) (int, bool) {
+	canaries, rest := order[:len(p.Canaries)], order[len(p.Canaries):]
+	began := time.Now()
+	batch, started := p.startWorkers(ctx, order, len(canaries))
+	if started < len(canaries) {
+		return started, false
+	}
+	batch.Wait()
+	select {
+	case <-p.canaryEmitted:
+	case <-ctx.Done():
+		p.finishUnlaunchedWorkers(rest)
+		return started, false
+	}
+	p.canary = CanaryStats{Targets: len(canaries), Passed: p.canaryCode == 0, Duration: time.Since(began)}
+	for _, cw := range canaries {
+		if cw.anyFailed {
+			p.canary.Failed++
+		}
+	}
+	if !p.canary.Passed {
+		p.endUnlaunchedWorkers(ctx, rest, ResultCanceled,
+			fmt.Errorf("not started: %d of %d canaries failed", p.canary.Failed, p.canary.Targets))
+		return started, false
+	}
+	proceed, kind, err := p.checkCanaries(ctx)
+	switch {
+	case ctx.Err() != nil:
+		p.finishUnlaunchedWorkers(rest)
+	case !proceed:
+		p.endUnlaunchedWorkers(ctx, rest, kind, err)
+	default:
+		p.canary.Continued = true
+	}
+	return started, p.canary.Continued
+}
+
+// checkCanaries asks CanaryCheck whether the other targets may start. The
+// run Deadline ends the wait; when the answer is no, it returns the kind and
+// error to report the other targets with.
+func (p *Pssh) checkCanaries(ctx context.Context) (bool, ResultKind, error) {
+	if p.CanaryCheck == nil {
+		return true, "", nil
+	}
+	// CanaryCheck may wait for the user, so it runs on its own.
+	answer := make(chan bool, one)
+	go func(stats CanaryStats) {
+		answer <- p.CanaryCheck(stats)
+	}(p.canary)
+	expired, stop := p.deadlineTimer()
+	defer stop()
+	select {
+	case proceed := <-answer:
+		return proceed, ResultCanceled, errors.New("not started: stopped after the canaries")
+	case <-expired:
+		return false, ResultTimeout, fmt.Errorf("not started: %w", p.deadlineErr())
+	case <-ctx.Done():
+		return false, ResultCanceled, ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package pssh
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunCanaries(t *testing.T) {
+	good := startSessionServer(t, func(*testSession) int { return 0 })
+	bad := startSessionServer(t, func(*testSession) int { return 3 })
+	for name, test := range map[string]struct {
+		hosts    []Host
+		proceed  bool
+		want     []string
+		wantCode int
+		canary   CanaryStats
+	}{
+		"passed": {
+			hosts: []Host{{Target: good}, {Target: good}, {Target: bad}}, proceed: true,
+			want:     []string{"1 true success", "check", "0 false success", "2 false remote_exit"},
+			wantCode: 3, canary: CanaryStats{Targets: 1, Passed: true, Continued: true},
+		},
+		"declined": {
+			hosts: []Host{{Target: good}, {Target: good}, {Target: bad}},
+			want:  []string{"1 true success", "check", "0 false canceled", "2 false canceled"},
+			// The canceled results fail the run.
+			wantCode: 1, canary: CanaryStats{Targets: 1, Passed: true},
+		},
+		"failed": {
+			hosts: []Host{{Target: good}, {Target: bad}, {Target: good}}, proceed: true,
+			want:     []string{"1 true remote_exit", "0 false canceled", "2 false canceled"},
+			wantCode: 3, canary: CanaryStats{Targets: 1, Failed: 1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			p := &Pssh{Config: &Config{
+				Concurrency: 5, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+				IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+				Hosts: test.hosts, Canaries: []int{1}, Command: "deploy", Stdout: io.Discard, Stderr: io.Discard,
+				CanaryCheck: func(CanaryStats) bool {
+					got = append(got, "check")
+					return test.proceed
+				},
+				ResultHandler: func(r *Result) error {
+					got = append(got, fmt.Sprintf("%d %t %s", r.Index, r.Canary, r.Kind))
+					return nil
+				},
+			}}
+			p.Init()
+			code := p.Run()
+			canary := p.Canary()
+			canary.Duration = 0
+			if code != test.wantCode || !reflect.DeepEqual(got, test.want) || canary != test.canary {
+				t.Fatalf("code=%d results=%q canary=%+v", code, got, canary)
+			}
+		})
+	}
+}
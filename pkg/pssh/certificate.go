@@ -0,0 +1,189 @@
+package pssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// CertificateSuffix names the certificate OpenSSH loads next to an identity.
+const CertificateSuffix = "-cert.pub"
+
+// ReadCertificate reads an OpenSSH user certificate such as id_ed25519-cert.pub.
+func ReadCertificate(path string) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s: not an SSH user certificate", path)
+	}
+	return cert, nil
+}
+
+// CheckCertificateValidity reports an error when cert is expired or not yet
+// valid at now.
+func CheckCertificateValidity(cert *ssh.Certificate, now time.Time) error {
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("certificate %q is not valid before %s", cert.KeyId, certificateTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("certificate %q expired at %s", cert.KeyId, certificateTime(cert.ValidBefore))
+	}
+	return nil
+}
+
+// DescribeCertificate summarizes the key ID, principals and validity window
+// of cert, and returns CheckCertificateValidity's error.
+func DescribeCertificate(cert *ssh.Certificate, now time.Time) (string, error) {
+	principals := "any"
+	if len(cert.ValidPrincipals) > 0 {
+		principals = strings.Join(cert.ValidPrincipals, ",")
+	}
+	description := fmt.Sprintf("key_id=%q principals=%s valid %s to %s", cert.KeyId, principals,
+		certificateTime(cert.ValidAfter), certificateTime(cert.ValidBefore))
+	if err := CheckCertificateValidity(cert, now); err != nil {
+		return description, err
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		remaining := time.Unix(int64(cert.ValidBefore), 0).Sub(now).Truncate(time.Minute)
+		description += fmt.Sprintf(" (expires in %s)", remaining)
+	}
+	return description, nil
+}
+
+func certificateTime(value uint64) string {
+	switch value {
+	case 0:
+		return "always"
+	case ssh.CertTimeInfinity:
+		return "forever"
+	}
+	return time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+}
+
+// ListAgentCertificates returns the user certificates held by the SSH agent
+// listening on socket.
+func ListAgentCertificates(socket string) ([]*ssh.Certificate, error) {
+	conn, err := newConnPools(socket, one).dialSocket()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, err
+	}
+	var certs []*ssh.Certificate
+	for _, key := range keys {
+		if cert, ok := agentPublicKey(key).(*ssh.Certificate); ok {
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+// certificateFile is a parsed certificate and the path it was read from.
+type certificateFile struct {
+	path string
+	cert *ssh.Certificate
+}
+
+// certificateFiles reads CertificateFiles once, warning about files that are
+// not user certificates.
+func (p *Pssh) certificateFiles() []certificateFile {
+	p.certificatesOnce.Do(func() {
+		for _, path := range p.CertificateFiles {
+			cert, err := ReadCertificate(expandTilde(path))
+			if err != nil {
+				p.warnf("certificate skipped: %s", err)
+				continue
+			}
+			p.certificates = append(p.certificates, certificateFile{path: path, cert: cert})
+		}
+	})
+	return p.certificates
+}
+
+// certificateSigners pairs signer with the certificates issued for its key:
+// the one next to the identity file at path and those of CertificateFiles.
+// Certificate signers come first, as in OpenSSH; certificates outside their
+// validity window are skipped with a warning.
+func (p *Pssh) certificateSigners(path string, signer ssh.Signer) []ssh.Signer {
+	var files []certificateFile
+	if path != "" {
+		if cert, err := ReadCertificate(path + CertificateSuffix); err == nil {
+			files = append(files, certificateFile{path: path + CertificateSuffix, cert: cert})
+		} else if !errors.Is(err, os.ErrNotExist) {
+			p.warnf("certificate skipped: %s", err)
+		}
+	}
+	files = p.usableCertificates(append(files, p.certificateFiles()...), signer.PublicKey())
+	return append(p.certSigners(files, signer), signer)
+}
+
+// agentSigners lists the keys of the agent, each preceded by the signers of
+// the CertificateFiles issued for it. The certificates of a key are matched
+// once, so that their warnings are not repeated for every target.
+func (p *Pssh) agentSigners() ([]ssh.Signer, error) {
+	signers, err := p.agent.Signers()
+	if err != nil || len(p.CertificateFiles) == 0 {
+		return signers, err
+	}
+	res := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		pub := signer.PublicKey()
+		if _, ok := pub.(*ssh.Certificate); !ok {
+			files, ok := p.agentCertificates.Load(string(pub.Marshal()))
+			if !ok {
+				files, _ = p.agentCertificates.LoadOrStore(string(pub.Marshal()), p.usableCertificates(p.certificateFiles(), pub))
+			}
+			res = append(res, p.certSigners(files.([]certificateFile), signer)...)
+		}
+		res = append(res, signer)
+	}
+	return res, nil
+}
+
+// usableCertificates returns the files certifying key that are within their
+// validity window, warning about the others.
+func (p *Pssh) usableCertificates(files []certificateFile, key ssh.PublicKey) []certificateFile {
+	keyBytes := key.Marshal()
+	var usable []certificateFile
+	for _, file := range files {
+		if !bytes.Equal(file.cert.Key.Marshal(), keyBytes) {
+			continue
+		}
+		if err := CheckCertificateValidity(file.cert, time.Now()); err != nil {
+			p.warnf("%s skipped: %s", file.path, err)
+			continue
+		}
+		usable = append(usable, file)
+	}
+	return usable
+}
+
+func (p *Pssh) certSigners(files []certificateFile, signer ssh.Signer) []ssh.Signer {
+	var signers []ssh.Signer
+	for _, file := range files {
+		certSigner, err := ssh.NewCertSigner(file.cert, signer)
+		if err != nil {
+			p.warnf("%s skipped: %s", file.path, err)
+			continue
+		}
+		signers = append(signers, certSigner)
+	}
+	return signers
+}
@@ -0,0 +1,128 @@
+package pssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+func testCertificate(t *testing.T, key ssh.PublicKey, validBefore time.Time) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key: key, CertType: ssh.UserCert, KeyId: "alice@example",
+		ValidPrincipals: []string{"alice", "deploy"},
+		ValidAfter:      uint64(validBefore.Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, testSigners["ecdsa"]); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIdentityCertificates(t *testing.T) {
+	dir := t.TempDir()
+	identity := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(identity, testdata.PEMBytes["ed25519"], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sibling := testCertificate(t, testPublicKeys["ed25519"], time.Now().Add(time.Hour))
+	if err := os.WriteFile(identity+CertificateSuffix, ssh.MarshalAuthorizedKey(sibling), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	explicit := filepath.Join(dir, "extra-cert.pub")
+	if err := os.WriteFile(explicit, ssh.MarshalAuthorizedKey(testCertificate(t, testPublicKeys["ed25519"], time.Now().Add(-time.Minute))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(dir, "rsa-cert.pub")
+	if err := os.WriteFile(unrelated, ssh.MarshalAuthorizedKey(testCertificate(t, testPublicKeys["rsa"], time.Now().Add(time.Hour))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	p := &Pssh{Config: &Config{IdentFiles: []string{identity}, CertificateFiles: []string{explicit, unrelated}, Stderr: &stderr}}
+	signers, err := p.identitySigners(p.readIdentFiles()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("signers = %d, want the sibling certificate and the key", len(signers))
+	}
+	if cert, ok := signers[0].PublicKey().(*ssh.Certificate); !ok || !bytes.Equal(cert.Marshal(), sibling.Marshal()) {
+		t.Fatalf("first signer = %T", signers[0].PublicKey())
+	}
+	if !strings.Contains(stderr.String(), explicit+" skipped: certificate \"alice@example\" expired at") {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
+
+func TestDescribeCertificate(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	cert := testCertificate(t, testPublicKeys["ed25519"], now.Add(90*time.Minute))
+	description, err := DescribeCertificate(cert, now)
+	want := `key_id="alice@example" principals=alice,deploy valid 2026-10-17T12:30:00Z to 2026-10-17T13:30:00Z`
+	if err == nil || !strings.HasPrefix(description, want) || !strings.Contains(err.Error(), "not valid before") {
+		t.Fatalf("description=%q err=%v", description, err)
+	}
+	description, err = DescribeCertificate(cert, now.Add(time.Hour))
+	if err != nil || !strings.HasSuffix(description, "(expires in 30m0s)") {
+		t.Fatalf("description=%q err=%v", description, err)
+	}
+	if _, err := DescribeCertificate(cert, now.Add(2*time.Hour)); err == nil || !strings.Contains(err.Error(), "expired at 2026-10-17T13:30:00Z") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestAgentPublicKeyParsesCertificates(t *testing.T) {
+	cert := testCertificate(t, testPublicKeys["ed25519"], time.Now().Add(time.Hour))
+	key := &agent.Key{Format: cert.Type(), Blob: cert.Marshal()}
+	if _, ok := agentPublicKey(key).(*ssh.Certificate); !ok {
+		t.Fatalf("agentPublicKey(cert) = %T", agentPublicKey(key))
+	}
+	if pub := agentPublicKey(&agent.Key{Format: "bogus"}); pub == nil {
+		t.Fatal("agentPublicKey(bogus) = nil")
+	}
+}
+
+func TestAgentCertificates(t *testing.T) {
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: testPrivateKeys["ed25519"]}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	valid := testCertificate(t, testPublicKeys["ed25519"], time.Now().Add(time.Hour))
+	validPath, expiredPath := filepath.Join(dir, "valid-cert.pub"), filepath.Join(dir, "expired-cert.pub")
+	if err := os.WriteFile(validPath, ssh.MarshalAuthorizedKey(valid), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(expiredPath, ssh.MarshalAuthorizedKey(testCertificate(t, testPublicKeys["ed25519"], time.Now().Add(-time.Minute))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	p := &Pssh{Config: &Config{CertificateFiles: []string{validPath, expiredPath}, Stderr: &stderr}}
+	p.agent = newAgentClient(newConnPools(serveTestAgent(t, keyring), 1))
+	for range 2 {
+		signers, err := p.agentSigners()
+		if err != nil || len(signers) != 2 {
+			t.Fatalf("signers=%d err=%v, want the certificate and the key", len(signers), err)
+		}
+		if cert, ok := signers[0].PublicKey().(*ssh.Certificate); !ok || !bytes.Equal(cert.Marshal(), valid.Marshal()) {
+			t.Fatalf("first signer = %T", signers[0].PublicKey())
+		}
+		signature, err := signers[0].Sign(rand.Reader, []byte("data"))
+		if err != nil || valid.Key.Verify([]byte("data"), signature) != nil {
+			t.Fatalf("certificate signer: %v", err)
+		}
+	}
+	if count := strings.Count(stderr.String(), expiredPath+" skipped:"); count != 1 {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
@@ -2,18 +2,40 @@ package pssh
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strings"
+	"syscall"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type conWork struct {
 	*Pssh
 	id           int
 	host         string
+	hostConf     Host
 	command      chan input
 	startSession func(ctx context.Context, conn sshClientIface, cmd input)
+	// failed records whether the last step of the target failed, and
+	// anyFailed whether any did.
+	failed, anyFailed bool
+	// canary marks a target of Config.Canaries, and pos is the place of the
+	// target in the launch order.
+	canary bool
+	pos    int
+}
+
+// recordResult notes a failed result of the target before it is reported.
+func (c *conWork) recordResult(res *result) {
+	c.failed = res.code != 0 && res.kind != ResultCanceled
+	if c.failed && !c.anyFailed {
+		c.anyFailed = true
+		c.failedTargets.Add(one)
+	}
 }
 
 // TemporaryError is network error
@@ -28,12 +50,28 @@ func (c *conWork) conWorker(ctx context.Context, config ssh.ClientConfig) {
 	if ctx.Err() != nil {
 		return
 	}
-	authMethods := c.mergeAuthMethods(c.getIdentFileAuthMethods(c.identFileData))
-	config.Auth = authMethods
+	if c.deadlinePassed() {
+		c.endSteps(ctx, ResultTimeout, fmt.Errorf("not started: %w", c.deadlineErr()))
+		return
+	}
+	config.Auth = c.targetAuthMethods(c.hostConf)
+	if c.hostConf.User != "" {
+		config.User = c.hostConf.User
+	}
+	addr := c.hostConf.DialAddr()
 	if c.Debug {
-		log.Printf("start ssh.Dial : %s", c.host)
+		log.Printf("start ssh.Dial : %s", addr)
+	}
+	dialer, err := c.targetDialer(ctx, c.hostConf)
+	var conn sshClientIface
+	if err == nil {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if !c.deadline.IsZero() {
+			dialCtx, cancel = context.WithDeadline(ctx, c.deadline)
+		}
+		conn, err = dialer.DialContext(dialCtx, "tcp", addr, &config)
+		cancel()
 	}
-	conn, err := c.sshDialer.DialContext(ctx, "tcp", c.host, &config)
 	if err != nil {
 		if ctx.Err() != nil {
 			return
@@ -45,14 +83,23 @@ func (c *conWork) conWorker(ctx context.Context, config ssh.ClientConfig) {
 				return
 			}
 			res := c.newResult(c.id, cmd.id)
-			res.kind = ResultConnectionFailed
+			res.kind = connectFailureKind(err)
 			res.code = connectFailureCode
 			res.err = fmt.Errorf("cannot connect [%s]: %w", c.host, err)
+			if c.deadlinePassed() {
+				res.kind, res.code = ResultTimeout, timeoutCode
+				res.err = fmt.Errorf("cannot connect [%s]: %w: %w", c.host, c.deadlineErr(), err)
+			}
+			c.recordResult(res)
 			select {
 			case <-ctx.Done():
 				_ = c.delReslt(res)
+				return
 			case cmd.results <- res:
 			}
+			if !cmd.last {
+				c.endSteps(ctx, ResultSkipped, errors.New("skipped: not connected"))
+			}
 		}
 		return
 	}
@@ -62,7 +109,75 @@ func (c *conWork) conWorker(ctx context.Context, config ssh.ClientConfig) {
 	}
 	// nolint: errcheck
 	defer conn.Close()
-	c.commandLoop(ctx, conn, false)
+	if c.ForwardAgent && c.agent != nil {
+		if err := forwardAgent(conn, c.agent); err != nil {
+			c.warnf("%s: %v", c.host, err)
+		}
+	}
+	c.commandLoop(ctx, conn, len(c.Steps) > 0)
+}
+
+// endSteps reports the steps still queued for the target, up to the last
+// one, as kind with err without running them.
+func (c *conWork) endSteps(ctx context.Context, kind ResultKind, err error) {
+	for {
+		var cmd input
+		select {
+		case <-ctx.Done():
+			return
+		case cmd = <-c.command:
+		}
+		res := c.newResult(c.id, cmd.id)
+		res.kind = kind
+		res.err = err
+		switch kind {
+		case ResultCanceled:
+			res.code = one
+		case ResultTimeout:
+			res.code = timeoutCode
+		}
+		select {
+		case <-ctx.Done():
+			_ = c.delReslt(res)
+			return
+		case cmd.results <- res:
+		}
+		if cmd.last {
+			return
+		}
+	}
+}
+
+// connectFailureKind classifies a failure to connect and authenticate. A
+// revoked key is a mismatch; a rejected host certificate is host_key_unknown
+// when its CA is not trusted and host_key_mismatch otherwise. Failures of a
+// jump host are classified the same way.
+func connectFailureKind(err error) ResultKind {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	var certErr *HostCertificateError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0, errors.As(err, &revokedErr):
+		return ResultHostKeyMismatch
+	case errors.As(err, &keyErr):
+		return ResultHostKeyUnknown
+	case errors.As(err, &certErr) && certErr.Reason == HostCertUntrustedCA:
+		return ResultHostKeyUnknown
+	case errors.As(err, &certErr):
+		return ResultHostKeyMismatch
+	case errors.As(err, &dnsErr):
+		return ResultDNSFailed
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ResultConnectionRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ResultConnectTimeout
+	case strings.Contains(err.Error(), "ssh: unable to authenticate"):
+		// x/crypto/ssh has no error type for exhausted auth methods.
+		return ResultAuthFailed
+	}
+	return ResultConnectionFailed
 }
 
 func (c *conWork) commandLoop(ctx context.Context, conn sshClientIface, loop bool) {
@@ -78,9 +193,13 @@ func (c *conWork) commandLoop(ctx context.Context, conn sshClientIface, loop boo
 				return
 			}
 			c.startSession(ctx, conn, cmd)
-		}
-		if !loop {
-			return
+			if !loop || cmd.last {
+				return
+			}
+			if c.failed && c.StopOnFailure {
+				c.endSteps(ctx, ResultSkipped, fmt.Errorf("skipped: step %d failed", cmd.id+one))
+				return
+			}
 		}
 	}
 }
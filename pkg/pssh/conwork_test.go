@@ -3,13 +3,20 @@ package pssh
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type conMock struct {
@@ -27,6 +34,7 @@ func (c *conMock) SetReadDeadline(t time.Time) error  { return nil }
 
 type conSSHMock struct {
 	closeFunc func()
+	dialErr   error
 }
 
 func (c *conSSHMock) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
@@ -41,14 +49,17 @@ func (c *conSSHMock) Close() error {
 	}
 	return nil
 }
-func (c *conSSHMock) Wait() error                                                   { return nil }
-func (c *conSSHMock) User() string                                                  { return "" }
-func (c *conSSHMock) SessionID() []byte                                             { return nil }
-func (c *conSSHMock) ClientVersion() []byte                                         { return nil }
-func (c *conSSHMock) ServerVersion() []byte                                         { return nil }
-func (c *conSSHMock) RemoteAddr() net.Addr                                          { return nil }
-func (c *conSSHMock) LocalAddr() net.Addr                                           { return nil }
-func (c *conSSHMock) Dial(n, addr string) (net.Conn, error)                         { return nil, nil }
+func (c *conSSHMock) Wait() error                           { return nil }
+func (c *conSSHMock) User() string                          { return "" }
+func (c *conSSHMock) SessionID() []byte                     { return nil }
+func (c *conSSHMock) ClientVersion() []byte                 { return nil }
+func (c *conSSHMock) ServerVersion() []byte                 { return nil }
+func (c *conSSHMock) RemoteAddr() net.Addr                  { return nil }
+func (c *conSSHMock) LocalAddr() net.Addr                   { return nil }
+func (c *conSSHMock) Dial(n, addr string) (net.Conn, error) { return nil, nil }
+func (c *conSSHMock) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, c.dialErr
+}
 func (c *conSSHMock) DialTCP(n string, laddr, raddr *net.TCPAddr) (net.Conn, error) { return nil, nil }
 func (c *conSSHMock) HandleChannelOpen(channelType string) <-chan ssh.NewChannel    { return nil }
 func (c *conSSHMock) Listen(n, addr string) (net.Listener, error)                   { return nil, nil }
@@ -147,6 +158,7 @@ func TestConWorker(t *testing.T) {
 			Pssh:         p,
 			id:           1,
 			host:         "host1",
+			hostConf:     Host{Target: "host1"},
 			command:      make(chan input, 1),
 			startSession: mockStartSessionWorker,
 		}
@@ -347,3 +359,130 @@ func TestSSHDialContextCancelsHandshake(t *testing.T) {
 		t.Fatal("SSH handshake was not canceled")
 	}
 }
+
+type recordingSSHDial struct {
+	addr string
+	user string
+}
+
+func (d *recordingSSHDial) DialContext(
+	_ context.Context,
+	_ string,
+	addr string,
+	config *ssh.ClientConfig,
+) (sshClientIface, error) {
+	d.addr, d.user = addr, config.User
+	return &conSSHMock{}, nil
+}
+
+func TestConWorkerUsesPerHostSettings(t *testing.T) {
+	p := &Pssh{Config: &Config{Concurrency: 1, User: "global"}}
+	p.Init()
+	dialer := &recordingSSHDial{}
+	p.sshDialer = dialer
+	c := p.newHostConWork(0, Host{Target: "web1:22", Addr: "10.0.0.5:2222", User: "deploy"})
+	c.startSession = mockStartSessionWorker
+	results := make(chan *result, 1)
+	c.command <- input{results: results}
+	c.conWorker(context.Background(), ssh.ClientConfig{User: p.User})
+	<-results
+	if dialer.addr != "10.0.0.5:2222" || dialer.user != "deploy" {
+		t.Fatalf("dialed addr=%q user=%q", dialer.addr, dialer.user)
+	}
+	if c.host != "web1:22" {
+		t.Fatalf("reported target=%q, want web1:22", c.host)
+	}
+}
+
+func TestConnectFailureKind(t *testing.T) {
+	mismatch := &knownhosts.KeyError{Want: []knownhosts.KnownKey{{Key: testPublicKeys["rsa"], Filename: "known_hosts", Line: 1}}}
+	for _, test := range []struct {
+		err  error
+		want ResultKind
+	}{
+		{fmt.Errorf("ssh: handshake failed: %w", &HostKeyError{Key: testPublicKeys["ed25519"], Err: mismatch}), ResultHostKeyMismatch},
+		{&HostKeyError{Key: testPublicKeys["ed25519"], Err: &knownhosts.KeyError{}}, ResultHostKeyUnknown},
+		{&knownhosts.RevokedError{}, ResultHostKeyMismatch},
+		{&HostCertificateError{Reason: HostCertUntrustedCA}, ResultHostKeyUnknown},
+		{&HostCertificateError{Reason: HostCertPrincipal}, ResultHostKeyMismatch},
+		{fmt.Errorf("jump host bastion:22: %w", &knownhosts.KeyError{}), ResultHostKeyUnknown},
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"), ResultAuthFailed},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "web1", IsNotFound: true}}, ResultDNSFailed},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ResultConnectionRefused},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, ResultConnectTimeout},
+		{context.DeadlineExceeded, ResultConnectTimeout},
+		{io.EOF, ResultConnectionFailed},
+	} {
+		if got := connectFailureKind(test.err); got != test.want || !got.ConnectFailed() {
+			t.Errorf("connectFailureKind(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+	if ResultRemoteExit.ConnectFailed() {
+		t.Fatal("remote_exit is not a connection failure")
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	var mu sync.Mutex
+	connections := map[*ssh.ServerConn]bool{}
+	addr := startSessionServer(t, func(s *testSession) int {
+		mu.Lock()
+		connections[s.conn] = true
+		mu.Unlock()
+		_, _ = fmt.Fprintln(s.channel, s.command)
+		if s.command == "false" {
+			return 1
+		}
+		return 0
+	})
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	_ = closed.Close()
+
+	run := func(stopOnFailure bool, hosts ...Host) (int, []string) {
+		t.Helper()
+		var got []string
+		p := &Pssh{Config: &Config{
+			Concurrency: 2, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+			IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+			Hosts: hosts, Steps: []string{"echo one", "false", "echo three"}, StopOnFailure: stopOnFailure,
+			Stdout: io.Discard, Stderr: io.Discard,
+			ResultHandler: func(r *Result) error {
+				var out strings.Builder
+				if _, err := r.Stdout.WriteTo(&out); err != nil {
+					return err
+				}
+				got = append(got, fmt.Sprintf("%d/%d/%d %s %d %q", r.Index, r.Step, r.Steps, r.Kind, r.ExitCode, out.String()))
+				return nil
+			},
+		}}
+		p.Init()
+		return p.Run(), got
+	}
+
+	code, got := run(false, Host{Target: addr})
+	want := []string{
+		`0/0/3 success 0 "echo one\n"`,
+		`0/1/3 remote_exit 1 "false\n"`,
+		`0/2/3 success 0 "echo three\n"`,
+	}
+	if code != 1 || !reflect.DeepEqual(got, want) || len(connections) != 1 {
+		t.Fatalf("code=%d connections=%d results=%q", code, len(connections), got)
+	}
+
+	code, got = run(true, Host{Target: addr}, Host{Target: closedAddr})
+	want = []string{
+		`0/0/3 success 0 "echo one\n"`,
+		`0/1/3 remote_exit 1 "false\n"`,
+		`0/2/3 skipped 0 ""`,
+		`1/0/3 connection_refused 255 ""`,
+		`1/1/3 skipped 0 ""`,
+		`1/2/3 skipped 0 ""`,
+	}
+	if code != 1 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("code=%d results=%q", code, got)
+	}
+}
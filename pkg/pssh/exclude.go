@@ -0,0 +1,57 @@
+package pssh
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HostExclusion removes targets from a run. Without an explicit port it
+// matches the host on every port.
+type HostExclusion struct {
+	Host   string
+	Port   int
+	Source string
+}
+
+// ParseHostExclusions expands a host pattern into exclusions that report
+// source as the reason.
+func ParseHostExclusions(value, source string) ([]HostExclusion, error) {
+	values, err := ExpandHostPattern(value)
+	if err != nil {
+		return nil, err
+	}
+	exclusions := make([]HostExclusion, 0, len(values))
+	for _, expanded := range values {
+		normalized, err := normalizeHost(expanded)
+		if err != nil {
+			return nil, err
+		}
+		host, port, _ := net.SplitHostPort(normalized)
+		exclusion := HostExclusion{Host: host, Source: source}
+		if _, _, err := net.SplitHostPort(expanded); err == nil {
+			exclusion.Port, _ = strconv.Atoi(port)
+		}
+		exclusions = append(exclusions, exclusion)
+	}
+	return exclusions, nil
+}
+
+// Matches reports whether the normalized host:port target is excluded.
+func (e HostExclusion) Matches(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || !strings.EqualFold(host, e.Host) {
+		return false
+	}
+	return e.Port == 0 || port == strconv.Itoa(e.Port)
+}
+
+// MatchHostExclusion returns the first exclusion matching target.
+func MatchHostExclusion(exclusions []HostExclusion, target string) (HostExclusion, bool) {
+	for _, exclusion := range exclusions {
+		if exclusion.Matches(target) {
+			return exclusion, true
+		}
+	}
+	return HostExclusion{}, false
+}
@@ -0,0 +1,36 @@
+package pssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHostExclusionMatches(t *testing.T) {
+	exclusions, err := ParseHostExclusions("{web1,db1:2222,[2001:db8::1]}", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]bool{
+		"web1:22":            true,
+		"WEB1:2222":          true,
+		"db1:2222":           true,
+		"db1:22":             false,
+		"[2001:db8::1]:2200": true,
+		"web10:22":           false,
+	} {
+		if _, got := MatchHostExclusion(exclusions, target); got != want {
+			t.Errorf("MatchHostExclusion(%q)=%t, want %t", target, got, want)
+		}
+	}
+	if _, err := ParseHostExclusions("web1:0", "test"); err == nil {
+		t.Fatal("ParseHostExclusions(web1:0) error=nil")
+	}
+}
+
+func TestReadHostsNegation(t *testing.T) {
+	path := writeInventory(t, "hosts.txt", "web[1-3] web2:2222\n!web2:22 # maintenance\n")
+	hosts, err := ReadHosts(path)
+	if err != nil || !reflect.DeepEqual(hosts, []string{"web1:22", "web3:22", "web2:2222"}) {
+		t.Fatalf("ReadHosts()=%v, %v", hosts, err)
+	}
+}
@@ -0,0 +1,428 @@
+package pssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec // hashed known_hosts names use HMAC-SHA1
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host-key policies, after OpenSSH StrictHostKeyChecking yes, accept-new
+// and no.
+const (
+	HostKeyPolicyStrict    = "strict"
+	HostKeyPolicyAcceptNew = "accept-new"
+	HostKeyPolicyInsecure  = "insecure"
+)
+
+// DefaultKnownHostsFile returns ~/.ssh/known_hosts.
+func DefaultKnownHostsFile() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// EffectiveHostKeyPolicy returns the policy in force; IgnoreHostKey means
+// HostKeyPolicyInsecure.
+func (c *Config) EffectiveHostKeyPolicy() string {
+	if c.IgnoreHostKey {
+		return HostKeyPolicyInsecure
+	}
+	if c.HostKeyPolicy == "" {
+		return HostKeyPolicyStrict
+	}
+	return c.HostKeyPolicy
+}
+
+// EffectiveKnownHostsFiles returns KnownHostsFiles or the default file.
+func (c *Config) EffectiveKnownHostsFiles() []string {
+	if len(c.KnownHostsFiles) > 0 {
+		return c.KnownHostsFiles
+	}
+	return []string{DefaultKnownHostsFile()}
+}
+
+// ValidateHostKeyPolicy verifies that the host-key policy of c, with its
+// known_hosts files, can be loaded.
+func (c *Config) ValidateHostKeyPolicy() error {
+	_, err := hostKeyCallback(c)
+	return err
+}
+
+// hostKeyCallback builds the host-key verification of config. Under
+// accept-new the first known_hosts file records new keys and is created when
+// missing.
+func hostKeyCallback(config *Config) (ssh.HostKeyCallback, error) {
+	policy := config.EffectiveHostKeyPolicy()
+	switch policy {
+	case HostKeyPolicyInsecure:
+		// nolint: gosec
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyPolicyStrict, HostKeyPolicyAcceptNew:
+	default:
+		return nil, fmt.Errorf("unknown host-key policy %q", policy)
+	}
+	files := config.EffectiveKnownHostsFiles()
+	if policy == HostKeyPolicyAcceptNew {
+		if err := createKnownHostsFile(files[0]); err != nil {
+			return nil, err
+		}
+	}
+	checker, err := newHostKeyChecker(files, config.HostCAFiles)
+	if err != nil {
+		return nil, err
+	}
+	if policy == HostKeyPolicyAcceptNew {
+		checker.acceptNew = files[0]
+	}
+	return func(addr string, remote net.Addr, key ssh.PublicKey) error {
+		if err := checker.Check(addr, remote, key); err != nil {
+			return &HostKeyError{Addr: addr, Key: key, Err: err}
+		}
+		return nil
+	}, nil
+}
+
+// HostKeyError is a rejected host key together with the key the host
+// presented. Err is a *knownhosts.KeyError, a *HostCertificateError or the
+// error of reading known_hosts.
+type HostKeyError struct {
+	Addr string
+	Key  ssh.PublicKey
+	Err  error
+}
+
+func (e *HostKeyError) Error() string { return e.Err.Error() }
+
+func (e *HostKeyError) Unwrap() error { return e.Err }
+
+func createKnownHostsFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Reasons a host certificate is rejected.
+const (
+	HostCertUntrustedCA = "untrusted_ca"
+	HostCertExpired     = "expired"
+	HostCertNotYetValid = "not_yet_valid"
+	HostCertPrincipal   = "principal_mismatch"
+	HostCertRevoked     = "revoked"
+	HostCertInvalid     = "invalid"
+)
+
+// HostCertificateError explains why the certificate a host presented was
+// rejected. Reason is one of the HostCert constants.
+type HostCertificateError struct {
+	Host   string
+	Reason string
+	Detail string
+}
+
+func (e *HostCertificateError) Error() string {
+	return fmt.Sprintf("host certificate of %s: %s", e.Host, e.Detail)
+}
+
+// HostAuthority is a CA trusted to sign host certificates. Patterns limit
+// it to matching host names as in known_hosts; none means every host.
+type HostAuthority struct {
+	Key      ssh.PublicKey
+	Patterns []string
+	Source   string
+}
+
+func (a HostAuthority) matches(addr string) bool {
+	return len(a.Patterns) == 0 || matchKnownHostsPatterns(a.Patterns, addr)
+}
+
+// ReadHostCAFile reads the CA keys of a --host-ca-file. Lines are either
+// public keys, trusted for every host, or known_hosts @cert-authority lines.
+func ReadHostCAFile(path string) ([]HostAuthority, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var authorities []HostAuthority
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", path, lineNumber)
+		if strings.HasPrefix(line, "@") {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+			if err != nil || marker != "cert-authority" {
+				return nil, fmt.Errorf("%s: expected a public key or an @cert-authority line", source)
+			}
+			authorities = append(authorities, HostAuthority{Key: key, Patterns: hosts, Source: source})
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		authorities = append(authorities, HostAuthority{Key: key, Source: source})
+	}
+	return authorities, scanner.Err()
+}
+
+// hostKeyChecker verifies plain host keys with knownhosts, and host
+// certificates against the @cert-authority lines of the known_hosts files
+// and the keys of the host CA files. @revoked lines reject keys, CAs and
+// certificates alike.
+type hostKeyChecker struct {
+	knownHosts  ssh.HostKeyCallback
+	authorities []HostAuthority
+	revoked     map[string]string // marshaled key -> source
+	caLines     map[string]bool   // file:line of @cert-authority lines
+	now         func() time.Time
+	// acceptNew is the known_hosts file that records unknown keys under
+	// the accept-new policy.
+	acceptNew string
+	acceptMu  sync.Mutex
+}
+
+func newHostKeyChecker(knownHostsFiles, caFiles []string) (*hostKeyChecker, error) {
+	callback, err := knownhosts.New(knownHostsFiles...)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "knownhosts.New")
+	}
+	checker := &hostKeyChecker{knownHosts: callback, revoked: map[string]string{}, caLines: map[string]bool{}, now: time.Now}
+	for _, file := range knownHostsFiles {
+		if err := checker.readKnownHostsMarkers(file); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range caFiles {
+		authorities, err := ReadHostCAFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("host CA file: %w", err)
+		}
+		checker.authorities = append(checker.authorities, authorities...)
+	}
+	return checker, nil
+}
+
+// readKnownHostsMarkers collects the @cert-authority and @revoked lines,
+// which knownhosts only applies to its own certificate checks.
+func (c *hostKeyChecker) readKnownHostsMarkers(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for lineNumber := 1; len(data) > 0; lineNumber++ {
+		line := data
+		if end := bytes.IndexByte(data, '\n'); end >= 0 {
+			line, data = data[:end], data[end+one:]
+		} else {
+			data = nil
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("@")) {
+			continue
+		}
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, lineNumber, err)
+		}
+		source := fmt.Sprintf("%s:%d", file, lineNumber)
+		switch marker {
+		case "cert-authority":
+			c.authorities = append(c.authorities, HostAuthority{Key: key, Patterns: hosts, Source: source})
+			c.caLines[source] = true
+		case "revoked":
+			c.revoked[string(key.Marshal())] = source
+		}
+	}
+	return nil
+}
+
+// Check implements ssh.HostKeyCallback.
+func (c *hostKeyChecker) Check(addr string, remote net.Addr, key ssh.PublicKey) error {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return c.checkOrAccept(addr, remote, key)
+	}
+	host := addr
+	if hostname, _, err := net.SplitHostPort(addr); err == nil {
+		host = hostname
+	}
+	reject := func(reason, format string, args ...any) error {
+		return &HostCertificateError{Host: host, Reason: reason, Detail: fmt.Sprintf(format, args...)}
+	}
+	for _, revokedKey := range []ssh.PublicKey{cert, cert.Key, cert.SignatureKey} {
+		if source, ok := c.revoked[string(revokedKey.Marshal())]; ok {
+			return reject(HostCertRevoked, "revoked by @revoked at %s", source)
+		}
+	}
+	if cert.CertType != ssh.HostCert {
+		return reject(HostCertInvalid, "not a host certificate")
+	}
+	if !c.trusts(cert.SignatureKey, addr) {
+		// Without a trusted CA the certified key may still be known as a
+		// plain key, as in OpenSSH.
+		err := c.checkOrAccept(addr, remote, cert.Key)
+		if !isUnknownHostKey(err) {
+			return err
+		}
+		return reject(HostCertUntrustedCA, "signed by an untrusted CA %s", ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+	now := uint64(c.now().Unix())
+	if now < cert.ValidAfter {
+		return reject(HostCertNotYetValid, "not valid before %s", certificateTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		return reject(HostCertExpired, "expired at %s", certificateTime(cert.ValidBefore))
+	}
+	if len(cert.ValidPrincipals) > 0 && !containsFold(cert.ValidPrincipals, host) {
+		return reject(HostCertPrincipal, "not valid for %q; principals: %s", host, strings.Join(cert.ValidPrincipals, ","))
+	}
+	checker := ssh.CertChecker{Clock: c.now}
+	if err := checker.CheckCert(host, cert); err != nil {
+		return reject(HostCertInvalid, "%s", strings.TrimPrefix(err.Error(), "ssh: "))
+	}
+	return nil
+}
+
+// checkPlain verifies a plain key with knownhosts, which also lists the CA
+// keys of matching @cert-authority lines as wanted host keys. Those are
+// dropped so that a host covered only by a CA reports an unknown key rather
+// than a mismatch.
+func (c *hostKeyChecker) checkPlain(callback ssh.HostKeyCallback, addr string, remote net.Addr, key ssh.PublicKey) error {
+	err := callback(addr, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	keyErr.Want = slices.DeleteFunc(keyErr.Want, func(known knownhosts.KnownKey) bool {
+		return c.caLines[fmt.Sprintf("%s:%d", known.Filename, known.Line)]
+	})
+	return keyErr
+}
+
+func isUnknownHostKey(err error) bool {
+	var keyErr *knownhosts.KeyError
+	return errors.As(err, &keyErr) && len(keyErr.Want) == 0
+}
+
+// checkOrAccept verifies a plain key and, under accept-new, records it when
+// the host has no key yet. A changed key always fails.
+func (c *hostKeyChecker) checkOrAccept(addr string, remote net.Addr, key ssh.PublicKey) error {
+	err := c.checkPlain(c.knownHosts, addr, remote, key)
+	if c.acceptNew == "" || !isUnknownHostKey(err) {
+		return err
+	}
+	return c.acceptNewKey(addr, remote, key)
+}
+
+// acceptNewKey appends the key of an unknown host to the accept-new file.
+// The file is locked and re-read first, so that concurrent workers and
+// processes neither duplicate a line nor overwrite a key that another one
+// recorded meanwhile.
+func (c *hostKeyChecker) acceptNewKey(addr string, remote net.Addr, key ssh.PublicKey) error {
+	c.acceptMu.Lock()
+	defer c.acceptMu.Unlock()
+	return lockKnownHostsFile(c.acceptNew, func(file *os.File) error {
+		current, err := knownhosts.New(c.acceptNew)
+		if err != nil {
+			return err
+		}
+		if err := c.checkPlain(current, addr, remote, key); !isUnknownHostKey(err) {
+			return err
+		}
+		line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n"
+		if _, err := file.WriteString(line); err != nil {
+			return fmt.Errorf("record host key in %s: %w", c.acceptNew, err)
+		}
+		return file.Sync()
+	})
+}
+
+// lockKnownHostsFile runs fn with path opened for appending under an
+// exclusive file lock, which other gopssh processes take as well.
+func lockKnownHostsFile(path string, fn func(*os.File) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("lock %s: %w", path, err)
+	}
+	defer func() { _ = unlockFile(file) }()
+	return fn(file)
+}
+
+func (c *hostKeyChecker) trusts(ca ssh.PublicKey, addr string) bool {
+	want := ca.Marshal()
+	for _, authority := range c.authorities {
+		if bytes.Equal(authority.Key.Marshal(), want) && authority.matches(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, want string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKnownHostsPatterns matches addr against the host patterns of a
+// known_hosts line, which follow ssh_config Host patterns and may also be
+// hashed. A port other than 22 is written [host]:port.
+func matchKnownHostsPatterns(patterns []string, addr string) bool {
+	name := knownhosts.Normalize(addr)
+	var plain []string
+	for _, pattern := range patterns {
+		hashed := strings.TrimPrefix(pattern, "!")
+		if !strings.HasPrefix(hashed, "|1|") {
+			plain = append(plain, pattern)
+			continue
+		}
+		if matchHashedHost(hashed, name) {
+			if hashed != pattern {
+				return false
+			}
+			plain = append(plain, "*")
+		}
+	}
+	return matchSSHConfigPatterns(plain, name)
+}
+
+func matchHashedHost(pattern, name string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, errSalt := base64.StdEncoding.DecodeString(parts[2])
+	hash, errHash := base64.StdEncoding.DecodeString(parts[3])
+	if errSalt != nil || errHash != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
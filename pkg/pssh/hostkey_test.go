@@ -0,0 +1,194 @@
+package pssh
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostCertificate(t *testing.T, ca ssh.Signer, validBefore time.Time, principals ...string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key: testPublicKeys["ed25519"], CertType: ssh.HostCert, KeyId: "web",
+		ValidPrincipals: principals, ValidBefore: uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeTestFile(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestHostKeyCheckerCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, otherCA := testSigners["ecdsa"], testSigners["rsa"]
+	knownHostsFile := writeTestFile(t, dir, "known_hosts",
+		"@cert-authority *.example.com,!db.example.com "+authorizedKey(ca.PublicKey()),
+		"plain.example.org "+authorizedKey(testPublicKeys["ed25519"]),
+	)
+	checker, err := newHostKeyChecker([]string{knownHostsFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	remote := &net.TCPAddr{}
+	tests := []struct {
+		name   string
+		addr   string
+		cert   *ssh.Certificate
+		reason string
+	}{
+		{"trusted", "web1.example.com:22", testHostCertificate(t, ca, later, "web1.example.com"), ""},
+		{"principal mismatch", "web2.example.com:22", testHostCertificate(t, ca, later, "web1.example.com"), HostCertPrincipal},
+		{"expired", "web1.example.com:22", testHostCertificate(t, ca, time.Now().Add(-time.Minute), "web1.example.com"), HostCertExpired},
+		{"untrusted CA", "web1.example.com:22", testHostCertificate(t, otherCA, later, "web1.example.com"), HostCertUntrustedCA},
+		{"negated pattern", "db.example.com:22", testHostCertificate(t, ca, later, "db.example.com"), HostCertUntrustedCA},
+		{"untrusted CA with known plain key", "plain.example.org:22", testHostCertificate(t, otherCA, later, "plain.example.org"), ""},
+	}
+	var keyErr *knownhosts.KeyError
+	if err := checker.Check("web1.example.com:22", remote, testPublicKeys["rsa"]); !errors.As(err, &keyErr) || len(keyErr.Want) != 0 {
+		t.Fatalf("plain key of a CA-only host: %v, want an unknown key", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checker.Check(test.addr, remote, test.cert)
+			var certErr *HostCertificateError
+			switch {
+			case test.reason == "" && err != nil:
+				t.Fatalf("Check() = %v", err)
+			case test.reason != "" && (!errors.As(err, &certErr) || certErr.Reason != test.reason):
+				t.Fatalf("Check() = %v, want reason %s", err, test.reason)
+			}
+		})
+	}
+}
+
+func TestHostKeyCheckerCAFileAndRevoked(t *testing.T) {
+	dir := t.TempDir()
+	ca := testSigners["ecdsa"]
+	cert := testHostCertificate(t, ca, time.Now().Add(time.Hour), "web1")
+	caFile := writeTestFile(t, dir, "host_ca.pub", "# fleet CA", authorizedKey(ca.PublicKey()))
+	knownHostsFile := writeTestFile(t, dir, "known_hosts", "")
+	checker, err := newHostKeyChecker([]string{knownHostsFile}, []string{caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Check("web1:2222", &net.TCPAddr{}, cert); err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+
+	revokedFile := writeTestFile(t, dir, "revoked", "@revoked * "+authorizedKey(testPublicKeys["ed25519"]))
+	checker, err = newHostKeyChecker([]string{revokedFile}, []string{caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certErr *HostCertificateError
+	if err := checker.Check("web1:22", &net.TCPAddr{}, cert); !errors.As(err, &certErr) || certErr.Reason != HostCertRevoked ||
+		!strings.Contains(err.Error(), "host certificate of web1: revoked by @revoked at "+revokedFile+":1") {
+		t.Fatalf("Check() = %v", err)
+	}
+	var revoked *knownhosts.RevokedError
+	if err := checker.Check("web1:22", &net.TCPAddr{}, testPublicKeys["ed25519"]); !errors.As(err, &revoked) {
+		t.Fatalf("Check(plain revoked key) = %v", err)
+	}
+
+	if _, err := ReadHostCAFile(writeTestFile(t, dir, "bad", "@revoked * "+authorizedKey(ca.PublicKey()))); err == nil {
+		t.Fatal("ReadHostCAFile accepted an @revoked line")
+	}
+}
+
+func TestMatchKnownHostsPatterns(t *testing.T) {
+	hashed := knownhosts.HashHostname("web1.example.com")
+	for _, test := range []struct {
+		patterns []string
+		addr     string
+		want     bool
+	}{
+		{[]string{"*.example.com"}, "web1.example.com:22", true},
+		{[]string{"*.example.com"}, "web1.example.com:2222", false},
+		{[]string{"[*.example.com]:2222"}, "web1.example.com:2222", true},
+		{[]string{"*.example.com", "!web1.example.com"}, "web1.example.com:22", false},
+		{[]string{hashed}, "web1.example.com:22", true},
+		{[]string{hashed}, "web2.example.com:22", false},
+		{[]string{"*", "!" + hashed}, "web1.example.com:22", false},
+	} {
+		if got := matchKnownHostsPatterns(test.patterns, test.addr); got != test.want {
+			t.Errorf("matchKnownHostsPatterns(%q, %q) = %t, want %t", test.patterns, test.addr, got, test.want)
+		}
+	}
+}
+
+func TestHostKeyPolicyAcceptNew(t *testing.T) {
+	dir := t.TempDir()
+	userFile := filepath.Join(dir, "ssh", "known_hosts")
+	globalFile := writeTestFile(t, dir, "ssh_known_hosts", "db1 "+authorizedKey(testPublicKeys["ecdsa"]))
+	config := &Config{HostKeyPolicy: HostKeyPolicyAcceptNew, KnownHostsFiles: []string{userFile, globalFile}}
+	callback, err := hostKeyCallback(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Go(func() {
+			addr := fmt.Sprintf("web%d:22", i%8)
+			if err := callback(addr, &net.TCPAddr{}, testPublicKeys["ed25519"]); err != nil {
+				t.Errorf("callback(%s) = %v", addr, err)
+			}
+		})
+	}
+	wg.Wait()
+	data, err := os.ReadFile(userFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 8 || !strings.Contains(string(data), "web3 ssh-ed25519 ") {
+		t.Fatalf("known_hosts = %q", data)
+	}
+
+	var keyErr *knownhosts.KeyError
+	for _, addr := range []string{"web3:22", "db1:22"} {
+		if err := callback(addr, &net.TCPAddr{}, testPublicKeys["rsa"]); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+			t.Fatalf("changed key of %s: %v, want a mismatch", addr, err)
+		}
+	}
+	if err := callback("[web1]:2222", &net.TCPAddr{}, testPublicKeys["rsa"]); err != nil {
+		t.Fatalf("new port: %v", err)
+	}
+	if data, _ := os.ReadFile(userFile); !strings.Contains(string(data), "[web1]:2222 ssh-rsa ") {
+		t.Fatalf("known_hosts = %q", data)
+	}
+
+	config.HostKeyPolicy = HostKeyPolicyStrict
+	strict, err := hostKeyCallback(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict("web99:22", &net.TCPAddr{}, testPublicKeys["ed25519"]); !isUnknownHostKey(err) {
+		t.Fatalf("strict unknown host = %v", err)
+	}
+	if _, err := hostKeyCallback(&Config{HostKeyPolicy: "sometimes"}); err == nil {
+		t.Fatal("unknown policy accepted")
+	}
+}
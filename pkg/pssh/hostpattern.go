@@ -0,0 +1,166 @@
+package pssh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxHostPatternExpansion bounds the number of targets one pattern may
+// produce, so that a typo cannot exhaust memory.
+const MaxHostPatternExpansion = 1 << 16
+
+// IsHostPattern reports whether value contains a range or brace expression.
+// Brackets around an IPv6 literal are not a pattern.
+func IsHostPattern(value string) bool {
+	if strings.Contains(value, "{") {
+		return true
+	}
+	for rest := value; ; {
+		start := strings.IndexByte(rest, '[')
+		if start < 0 {
+			return false
+		}
+		end := strings.IndexByte(rest[start:], ']')
+		if end < 0 || !strings.Contains(rest[start:start+end], ":") {
+			return true
+		}
+		rest = rest[start+end:]
+	}
+}
+
+// ExpandHostPattern expands pdsh/ClusterShell-style host patterns:
+// numeric ranges with zero padding (web[01-20]), alphabetic ranges
+// (rack[a-c]), lists inside ranges (node[1-3,7]) and brace alternatives
+// ({web,db}1). Several expressions produce their cartesian product in
+// left-to-right order.
+func ExpandHostPattern(pattern string) ([]string, error) {
+	results := []string{""}
+	for rest := pattern; rest != ""; {
+		var alternatives []string
+		switch rest[0] {
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid host pattern %q: missing ]", pattern)
+			}
+			body := rest[one:end]
+			if strings.Contains(body, ":") {
+				alternatives = []string{rest[:end+one]}
+			} else {
+				var err error
+				if alternatives, err = expandHostRange(body); err != nil {
+					return nil, fmt.Errorf("invalid host pattern %q: %w", pattern, err)
+				}
+			}
+			rest = rest[end+one:]
+		case '{':
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid host pattern %q: missing }", pattern)
+			}
+			alternatives = strings.Split(rest[one:end], ",")
+			rest = rest[end+one:]
+		case ']', '}':
+			return nil, fmt.Errorf("invalid host pattern %q: unexpected %c", pattern, rest[0])
+		default:
+			end := strings.IndexAny(rest, "[]{}")
+			if end < 0 {
+				end = len(rest)
+			}
+			alternatives = []string{rest[:end]}
+			rest = rest[end:]
+		}
+		if len(results)*len(alternatives) > MaxHostPatternExpansion {
+			return nil, fmt.Errorf("invalid host pattern %q: expands to more than %d hosts", pattern, MaxHostPatternExpansion)
+		}
+		product := make([]string, 0, len(results)*len(alternatives))
+		for _, prefix := range results {
+			for _, alternative := range alternatives {
+				product = append(product, prefix+alternative)
+			}
+		}
+		results = product
+	}
+	return results, nil
+}
+
+// expandHostRange expands the comma-separated items of a [...] expression.
+func expandHostRange(body string) ([]string, error) {
+	var values []string
+	for _, item := range strings.Split(body, ",") {
+		low, high, isRange := strings.Cut(item, "-")
+		if !isRange {
+			if item == "" || !isHostRangeBound(item) {
+				return nil, fmt.Errorf("invalid range item %q", item)
+			}
+			values = append(values, item)
+			continue
+		}
+		expanded, err := expandHostRangeItem(low, high)
+		if err != nil {
+			return nil, err
+		}
+		if len(values)+len(expanded) > MaxHostPatternExpansion {
+			return nil, fmt.Errorf("range %q is too large", item)
+		}
+		values = append(values, expanded...)
+	}
+	return values, nil
+}
+
+func expandHostRangeItem(low, high string) ([]string, error) {
+	item := low + "-" + high
+	if isDigits(low) && isDigits(high) {
+		first, errLow := strconv.Atoi(low)
+		last, errHigh := strconv.Atoi(high)
+		if errLow != nil || errHigh != nil || first > last {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+		if last-first >= MaxHostPatternExpansion {
+			return nil, fmt.Errorf("range %q is too large", item)
+		}
+		width := 0
+		if len(low) > one && low[0] == '0' {
+			width = len(low)
+		}
+		values := make([]string, 0, last-first+one)
+		for n := first; n <= last; n++ {
+			values = append(values, fmt.Sprintf("%0*d", width, n))
+		}
+		return values, nil
+	}
+	if len(low) == one && len(high) == one && isASCIILetter(low[0]) && isASCIILetter(high[0]) &&
+		isLowerASCII(low[0]) == isLowerASCII(high[0]) && low[0] <= high[0] {
+		var values []string
+		for c := low[0]; c <= high[0]; c++ {
+			values = append(values, string(c))
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("invalid range %q", item)
+}
+
+func isHostRangeBound(value string) bool {
+	return isDigits(value) || len(value) == one && isASCIILetter(value[0])
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isLowerASCII(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
@@ -0,0 +1,68 @@
+package pssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandHostPattern(t *testing.T) {
+	for pattern, want := range map[string][]string{
+		"web[01-03].example.com": {"web01.example.com", "web02.example.com", "web03.example.com"},
+		"node[8-10]":             {"node8", "node9", "node10"},
+		"node[1-2,7]":            {"node1", "node2", "node7"},
+		"rack[a-c]":              {"racka", "rackb", "rackc"},
+		"{web,db}1:2222":         {"web1:2222", "db1:2222"},
+		"{web,db}[1-2]":          {"web1", "web2", "db1", "db2"},
+		"[2001:db8::1]:22":       {"[2001:db8::1]:22"},
+		"plain.example.com":      {"plain.example.com"},
+		"host[09-11]{-a,-b}.lan": {"host09-a.lan", "host09-b.lan", "host10-a.lan", "host10-b.lan", "host11-a.lan", "host11-b.lan"},
+	} {
+		got, err := ExpandHostPattern(pattern)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ExpandHostPattern(%q)=%q, %v; want %q", pattern, got, err, want)
+		}
+	}
+	for pattern, want := range map[string]string{
+		"web[1-3":        "missing ]",
+		"web{a,b":        "missing }",
+		"web]1":          "unexpected ]",
+		"web[3-1]":       `invalid range "3-1"`,
+		"web[a-C]":       `invalid range "a-C"`,
+		"web[1-]":        `invalid range "1-"`,
+		"web[x1]":        `invalid range item "x1"`,
+		"n[0-9999][0-9]": "expands to more than",
+	} {
+		_, err := ExpandHostPattern(pattern)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ExpandHostPattern(%q) error=%v, want %q", pattern, err, want)
+		}
+	}
+}
+
+func TestIsHostPattern(t *testing.T) {
+	for value, want := range map[string]bool{
+		"web[1-3]":         true,
+		"{a,b}.example":    true,
+		"[2001:db8::1]:22": false,
+		"[::1]:2222-[1-2]": true,
+		"web1.example:22":  false,
+	} {
+		if got := IsHostPattern(value); got != want {
+			t.Errorf("IsHostPattern(%q)=%t, want %t", value, got, want)
+		}
+	}
+}
+
+func TestReadHostsExpandsPatterns(t *testing.T) {
+	path := writeInventory(t, "hosts.ini", "[web]\nweb[1-2] port=2222\n")
+	hosts, err := ReadHosts(path)
+	if err != nil || !reflect.DeepEqual(hosts, []string{"web1:2222", "web2:2222"}) {
+		t.Fatalf("ReadHosts()=%v, %v", hosts, err)
+	}
+	legacy := writeInventory(t, "hosts.txt", "db[08-09] [2001:db8::1]:22\n")
+	hosts, err = ReadHosts(legacy)
+	if err != nil || !reflect.DeepEqual(hosts, []string{"db08:22", "db09:22", "[2001:db8::1]:22"}) {
+		t.Fatalf("ReadHosts(legacy)=%v, %v", hosts, err)
+	}
+}
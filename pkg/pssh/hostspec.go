@@ -0,0 +1,71 @@
+package pssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseHostLine splits a line of a plain hosts file into its targets and the
+// options that apply to all of them. Targets are written as
+// [user@]host[:port]; options are key=value words accepting the inventory
+// variables, for example "web1 web2 user=admin identity=~/.ssh/legacy_rsa".
+// Double quotes group words, and a # starts a comment.
+func ParseHostLine(line string) ([]string, InventoryVars, error) {
+	var vars InventoryVars
+	fields, err := splitInventoryLine(strings.SplitN(line, "#", 2)[0])
+	if err != nil {
+		return nil, vars, err
+	}
+	var targets []string
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			targets = append(targets, field)
+			continue
+		}
+		if err := vars.set(key, value); err != nil {
+			return nil, vars, err
+		}
+	}
+	if len(targets) == 0 && len(fields) > 0 {
+		return nil, vars, fmt.Errorf("options without a host: %q", strings.TrimSpace(line))
+	}
+	return targets, vars, nil
+}
+
+// SplitTargetUser separates the user of a user@host[:port] target. The last @
+// ends the user, as in ssh.
+func SplitTargetUser(target string) (string, string, error) {
+	at := strings.LastIndex(target, "@")
+	if at < 0 {
+		return "", target, nil
+	}
+	user := target[:at]
+	if user == "" || strings.ContainsAny(user, " \t") {
+		return "", "", fmt.Errorf("invalid user in %q", target)
+	}
+	return user, target[at+one:], nil
+}
+
+// hostWithVars builds the engine target for a normalized address carrying
+// the user and options of its hosts-file line or inventory.
+func hostWithVars(target, user string, vars InventoryVars) (Host, error) {
+	host := Host{
+		Target:       target,
+		User:         valueOr(user, vars.User),
+		IdentFiles:   vars.IdentityFiles,
+		ProxyCommand: vars.ProxyCommand,
+	}
+	switch {
+	case strings.EqualFold(vars.Jump, "none"):
+		// An empty non-nil chain connects directly despite Config.JumpHosts.
+		host.JumpHosts = []JumpHost{}
+	case vars.Jump != "":
+		hops, err := ParseJumpHosts(vars.Jump)
+		if err != nil {
+			return host, err
+		}
+		host.JumpHosts = hops
+	}
+	return host, nil
+}
@@ -0,0 +1,63 @@
+package pssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHostLine(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	targets, vars, err := ParseHostLine(`web1 admin@web2:2222 identity=~/.ssh/legacy_rsa user=root proxy_command="nc %h %p" # old boxes`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []string{"web1", "admin@web2:2222"}) || vars.User != "root" ||
+		vars.ProxyCommand != "nc %h %p" || !reflect.DeepEqual(vars.IdentityFiles, []string{"/home/test/.ssh/legacy_rsa"}) {
+		t.Fatalf("targets=%v vars=%+v", targets, vars)
+	}
+	for line, want := range map[string]string{
+		"web1 colour=blue": `unknown variable "colour"`,
+		"user=root":        "options without a host",
+		`web1 user="root`:  "unterminated quote",
+	} {
+		if _, _, err := ParseHostLine(line); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseHostLine(%q) error=%v, want %q", line, err, want)
+		}
+	}
+}
+
+func TestSplitTargetUser(t *testing.T) {
+	user, target, err := SplitTargetUser("ci@corp@[2001:db8::1]:22")
+	if err != nil || user != "ci@corp" || target != "[2001:db8::1]:22" {
+		t.Fatalf("SplitTargetUser()=%q, %q, %v", user, target, err)
+	}
+	if _, _, err := SplitTargetUser("@web1"); err == nil {
+		t.Fatal("SplitTargetUser(@web1) error=nil")
+	}
+}
+
+func TestRunHostsAppliesHostsFileOptions(t *testing.T) {
+	path := writeInventory(t, "hosts.txt", "web1 admin@web2:2222 user=root identity=/keys/legacy jump=bastion\n!web1\ndb1\n")
+	p := &Pssh{Config: &Config{Hostsfile: path}}
+	hosts, err := p.runHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Host{
+		{Target: "web2:2222", User: "admin", IdentFiles: []string{"/keys/legacy"}, JumpHosts: []JumpHost{{Addr: "bastion:22"}}},
+		{Target: "db1:22"},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("runHosts()=%+v", hosts)
+	}
+
+	p.Hostsfile = writeInventory(t, "direct.txt", "web3 jump=none\n")
+	p.JumpHosts = []JumpHost{{Addr: "bastion:22"}}
+	if hosts, err = p.runHosts(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].JumpHosts == nil || len(hosts[0].JumpHosts) != 0 {
+		t.Fatalf("runHosts() with jump=none=%+v", hosts)
+	}
+}
@@ -0,0 +1,88 @@
+package pssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPassphraseRequired reports an encrypted identity without a passphrase.
+var ErrPassphraseRequired = errors.New("encrypted; a passphrase is required")
+
+// ParseIdentity parses a private key, decrypting it with passphrase when it
+// is encrypted. encrypted is reported even when parsing fails.
+func ParseIdentity(data []byte, passphrase Secret) (signer ssh.Signer, encrypted bool, err error) {
+	signer, err = ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, false, err
+	}
+	if passphrase == "" {
+		return nil, true, ErrPassphraseRequired
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	return signer, true, err
+}
+
+// identity is one identity file parsed at most once per run, so that an
+// encrypted key asks for its passphrase once however many targets use it.
+type identity struct {
+	once    sync.Once
+	signers []ssh.Signer
+	err     error
+}
+
+// identitySigners returns the cached signers of an identity file's
+// contents: its certificates, then the key itself.
+func (p *Pssh) identitySigners(data []byte) ([]ssh.Signer, error) {
+	value, _ := p.identities.LoadOrStore(string(data), &identity{})
+	key := value.(*identity)
+	key.once.Do(func() {
+		signer, err := p.parseIdentity(data)
+		if err != nil {
+			key.err = err
+			if len(data) > 0 {
+				p.warnf("identity %s skipped: %s", p.identityName(data), err)
+			}
+			return
+		}
+		path, _ := p.identityPaths.Load(string(data))
+		name, _ := path.(string)
+		key.signers = p.certificateSigners(name, signer)
+	})
+	return key.signers, key.err
+}
+
+// parseIdentity decrypts encrypted keys with IdentityPassphrase, or else
+// with a passphrase asked through Prompt.
+func (p *Pssh) parseIdentity(data []byte) (ssh.Signer, error) {
+	signer, encrypted, err := ParseIdentity(data, p.IdentityPassphrase)
+	if !encrypted || p.IdentityPassphrase != "" || p.Prompt == nil {
+		return signer, err
+	}
+	passphrase, err := p.Prompt("", fmt.Sprintf("Enter passphrase for key %s: ", p.identityName(data)), false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPassphraseRequired, err)
+	}
+	signer, _, err = ParseIdentity(data, Secret(passphrase))
+	return signer, err
+}
+
+// identityName returns the path the contents were read from.
+func (p *Pssh) identityName(data []byte) string {
+	if name, ok := p.identityPaths.Load(string(data)); ok {
+		return name.(string)
+	}
+	return "file"
+}
+
+func (p *Pssh) warnf(format string, args ...any) {
+	stderr := p.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	_, _ = fmt.Fprintf(stderr, "gopssh: "+format+"\n", args...)
+}
@@ -0,0 +1,62 @@
+package pssh
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+func TestParseIdentity(t *testing.T) {
+	if _, encrypted, err := ParseIdentity(testdata.PEMBytes["ed25519"], ""); encrypted || err != nil {
+		t.Fatalf("plain key: encrypted=%t err=%v", encrypted, err)
+	}
+	key := testdata.PEMEncryptedKeys[0]
+	if _, encrypted, err := ParseIdentity(key.PEMBytes, ""); !encrypted || !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("encrypted key without passphrase: encrypted=%t err=%v", encrypted, err)
+	}
+	if _, encrypted, err := ParseIdentity(key.PEMBytes, "wrong"); !encrypted || err == nil {
+		t.Fatalf("encrypted key with wrong passphrase: encrypted=%t err=%v", encrypted, err)
+	}
+	if signer, _, err := ParseIdentity(key.PEMBytes, Secret(key.EncryptionKey)); err != nil || signer == nil {
+		t.Fatalf("encrypted key with passphrase: err=%v", err)
+	}
+}
+
+func TestEncryptedIdentityPromptsOnce(t *testing.T) {
+	key := testdata.PEMEncryptedKeys[0]
+	var prompts atomic.Int32
+	var stderr bytes.Buffer
+	p := &Pssh{Config: &Config{Stderr: &stderr, Prompt: func(target, question string, echo bool) (string, error) {
+		prompts.Add(1)
+		if !strings.Contains(question, "passphrase for key") || echo {
+			t.Errorf("question=%q echo=%t", question, echo)
+		}
+		return key.EncryptionKey, nil
+	}}}
+	for range 3 {
+		if methods := p.getIdentFileAuthMethods([][]byte{key.PEMBytes}); len(methods) != 1 {
+			t.Fatalf("methods = %d", len(methods))
+		}
+	}
+	if prompts.Load() != 1 || stderr.Len() != 0 {
+		t.Fatalf("prompts=%d stderr=%q", prompts.Load(), stderr.String())
+	}
+}
+
+func TestUndecryptableIdentityWarnsOnce(t *testing.T) {
+	key := testdata.PEMEncryptedKeys[0]
+	var stderr bytes.Buffer
+	p := &Pssh{Config: &Config{Stderr: &stderr}}
+	for range 2 {
+		if methods := p.getIdentFileAuthMethods([][]byte{key.PEMBytes}); len(methods) != 0 {
+			t.Fatalf("methods = %d", len(methods))
+		}
+	}
+	if got := strings.Count(stderr.String(), "encrypted; a passphrase is required"); got != 1 {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
@@ -0,0 +1,620 @@
+package pssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InventoryAllGroup selects every host of an inventory. Its variables apply to
+// every host with the lowest precedence.
+const InventoryAllGroup = "all"
+
+var (
+	inventoryGroupPattern   = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	inventorySectionPattern = regexp.MustCompile(`^\[([A-Za-z0-9_.-]+)(?::(vars|children))?\]$`)
+)
+
+// Inventory is a hosts file with named groups, nested groups and per-host
+// variables, written in Ansible-like INI or YAML syntax.
+type Inventory struct {
+	path   string
+	groups map[string]*inventoryGroup
+	order  []*inventoryGroup
+	hosts  map[string]*inventoryHost
+	names  []string
+}
+
+type inventoryGroup struct {
+	name     string
+	line     int
+	hosts    []string
+	children []string
+	vars     InventoryVars
+}
+
+type inventoryHost struct {
+	line int
+	vars InventoryVars
+}
+
+// InventoryVars are the per-host settings an inventory can assign. Empty
+// values leave the setting to ssh_config and the command line.
+type InventoryVars struct {
+	User          string            `json:"user,omitempty"`
+	Port          int               `json:"port,omitempty"`
+	IdentityFiles []string          `json:"identity_files,omitempty"`
+	Jump          string            `json:"jump,omitempty"`
+	ProxyCommand  string            `json:"proxy_command,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// InventoryHost is one host selected from an inventory with the variables
+// that apply to it.
+type InventoryHost struct {
+	Name   string
+	Line   int
+	Groups []string
+	Vars   InventoryVars
+}
+
+// Target returns the host name with the port variable applied when the name
+// does not carry a port of its own.
+func (h InventoryHost) Target() string {
+	if _, _, err := net.SplitHostPort(h.Name); err == nil || h.Vars.Port == 0 {
+		return h.Name
+	}
+	host := h.Name
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") && strings.Contains(host, ":") {
+		host = host[one : len(host)-one]
+	}
+	return net.JoinHostPort(host, strconv.Itoa(h.Vars.Port))
+}
+
+// SplitInventoryPath separates a path:group hosts-file argument. A value that
+// names an existing file is returned unchanged.
+func SplitInventoryPath(value string) (string, string) {
+	if _, err := os.Stat(value); err == nil {
+		return value, ""
+	}
+	colon := strings.LastIndex(value, ":")
+	if colon <= 0 || !inventoryGroupPattern.MatchString(value[colon+one:]) {
+		return value, ""
+	}
+	return value[:colon], value[colon+one:]
+}
+
+// IsInventoryFile reports whether path uses the group-aware format rather
+// than the legacy whitespace-separated host list. YAML is recognized by its
+// .yaml or .yml extension, and INI by its .ini extension or a [group] section
+// header, which is never a valid legacy target.
+func IsInventoryFile(path string) (bool, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".ini":
+		return true, nil
+	}
+	// nolint: gosec
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if inventorySectionPattern.MatchString(strings.TrimSpace(scanner.Text())) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// ReadInventory parses a YAML (.yaml, .yml) or INI inventory file.
+func ReadInventory(path string) (*Inventory, error) {
+	// nolint: gosec
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	inventory := &Inventory{path: path, groups: map[string]*inventoryGroup{}, hosts: map[string]*inventoryHost{}}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = inventory.parseYAML(file)
+	default:
+		err = inventory.parseINI(file)
+	}
+	if err == nil {
+		err = inventory.checkChildren()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+func (inv *Inventory) errorf(line int, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: "+format, append([]any{inv.path, line}, args...)...)
+}
+
+// Hosts returns the hosts of groups, including those of nested groups, in
+// file order. No groups, or the all group, select every host.
+func (inv *Inventory) Hosts(groups ...string) ([]InventoryHost, error) {
+	members := make(map[*inventoryGroup]map[string]bool, len(inv.order))
+	depths := make(map[*inventoryGroup]int, len(inv.order))
+	for _, group := range inv.order {
+		members[group] = map[string]bool{}
+		inv.collect(group, members[group])
+		depths[group] = inv.depth(group)
+	}
+	var selected map[string]bool
+	if len(groups) > 0 && !slices.Contains(groups, InventoryAllGroup) {
+		selected = map[string]bool{}
+		for _, name := range groups {
+			group, ok := inv.groups[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown group %q", inv.path, name)
+			}
+			maps.Copy(selected, members[group])
+		}
+	}
+	var result []InventoryHost
+	for _, name := range inv.names {
+		if selected != nil && !selected[name] {
+			continue
+		}
+		result = append(result, inv.resolve(name, members, depths))
+	}
+	return result, nil
+}
+
+// Groups returns the names of the groups defined in the inventory.
+func (inv *Inventory) Groups() []string {
+	names := make([]string, len(inv.order))
+	for i, group := range inv.order {
+		names[i] = group.name
+	}
+	return names
+}
+
+func (inv *Inventory) collect(group *inventoryGroup, hosts map[string]bool) {
+	for _, host := range group.hosts {
+		hosts[host] = true
+	}
+	for _, child := range group.children {
+		inv.collect(inv.groups[child], hosts)
+	}
+}
+
+// resolve merges the variables of every group containing host, outer groups
+// first and then in file order, followed by the host's own variables.
+func (inv *Inventory) resolve(
+	name string,
+	members map[*inventoryGroup]map[string]bool,
+	depths map[*inventoryGroup]int,
+) InventoryHost {
+	host := InventoryHost{Name: name, Line: inv.hosts[name].line}
+	var vars InventoryVars
+	if all, ok := inv.groups[InventoryAllGroup]; ok {
+		vars = vars.merge(all.vars)
+	}
+	var containing []*inventoryGroup
+	for _, group := range inv.order {
+		if members[group][name] && group.name != InventoryAllGroup {
+			containing = append(containing, group)
+			host.Groups = append(host.Groups, group.name)
+		}
+	}
+	slices.SortStableFunc(containing, func(a, b *inventoryGroup) int {
+		return depths[a] - depths[b]
+	})
+	for _, group := range containing {
+		vars = vars.merge(group.vars)
+	}
+	host.Vars = vars.merge(inv.hosts[name].vars)
+	return host
+}
+
+// depth is the length of the longest chain of parent groups above group.
+func (inv *Inventory) depth(group *inventoryGroup) int {
+	depth := 0
+	for _, parent := range inv.order {
+		if slices.Contains(parent.children, group.name) {
+			depth = max(depth, inv.depth(parent)+one)
+		}
+	}
+	return depth
+}
+
+func (inv *Inventory) checkChildren() error {
+	state := map[string]int{}
+	var visit func(group *inventoryGroup) error
+	visit = func(group *inventoryGroup) error {
+		switch state[group.name] {
+		case one:
+			return inv.errorf(group.line, "group %q contains itself", group.name)
+		case 2:
+			return nil
+		}
+		state[group.name] = one
+		for _, child := range group.children {
+			childGroup, ok := inv.groups[child]
+			if !ok {
+				return inv.errorf(group.line, "group %q: unknown child group %q", group.name, child)
+			}
+			if err := visit(childGroup); err != nil {
+				return err
+			}
+		}
+		state[group.name] = 2
+		return nil
+	}
+	for _, group := range inv.order {
+		if err := visit(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) group(name string, line int) (*inventoryGroup, error) {
+	if !inventoryGroupPattern.MatchString(name) {
+		return nil, inv.errorf(line, "invalid group name %q", name)
+	}
+	group, ok := inv.groups[name]
+	if !ok {
+		group = &inventoryGroup{name: name, line: line}
+		inv.groups[name] = group
+		inv.order = append(inv.order, group)
+	}
+	return group, nil
+}
+
+func (inv *Inventory) addHost(group *inventoryGroup, name string, line int, vars InventoryVars) {
+	host, ok := inv.hosts[name]
+	if !ok {
+		host = &inventoryHost{line: line}
+		inv.hosts[name] = host
+		inv.names = append(inv.names, name)
+	}
+	host.vars = host.vars.merge(vars)
+	if !slices.Contains(group.hosts, name) {
+		group.hosts = append(group.hosts, name)
+	}
+}
+
+// parseINI reads [group] host lines with key=value variables, [group:vars]
+// key=value lines and [group:children] group names. Hosts listed before the
+// first section belong to the ungrouped group.
+func (inv *Inventory) parseINI(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var group *inventoryGroup
+	section := ""
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if match := inventorySectionPattern.FindStringSubmatch(text); match != nil {
+			var err error
+			if group, err = inv.group(match[1], line); err != nil {
+				return err
+			}
+			section = match[2]
+			continue
+		}
+		if group == nil {
+			var err error
+			if group, err = inv.group("ungrouped", line); err != nil {
+				return err
+			}
+		}
+		switch section {
+		case "vars":
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				return inv.errorf(line, "expected key=value, got %q", text)
+			}
+			if err := group.vars.set(strings.TrimSpace(key), unquoteInventoryValue(strings.TrimSpace(value))); err != nil {
+				return inv.errorf(line, "%w", err)
+			}
+		case "children":
+			if !inventoryGroupPattern.MatchString(text) {
+				return inv.errorf(line, "invalid group name %q", text)
+			}
+			group.children = append(group.children, text)
+		default:
+			fields, err := splitInventoryLine(text)
+			if err != nil {
+				return inv.errorf(line, "%w", err)
+			}
+			var vars InventoryVars
+			for _, field := range fields[one:] {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return inv.errorf(line, "expected key=value, got %q", field)
+				}
+				if err := vars.set(key, value); err != nil {
+					return inv.errorf(line, "%w", err)
+				}
+			}
+			inv.addHost(group, fields[0], line, vars)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitInventoryLine splits an INI host line on whitespace. Double quotes
+// group words, and a # outside quotes starts a comment.
+func splitInventoryLine(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case quoted:
+			field.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case r == '#' && !inField:
+			return fields, nil
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func unquoteInventoryValue(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseYAML reads a mapping of group names to groups with optional hosts
+// (a mapping of names to variables, or a list of names), vars and children
+// (a list of names, or a mapping of names to nested groups).
+func (inv *Inventory) parseYAML(r io.Reader) error {
+	var document yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&document); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", inv.path, err)
+	}
+	root := document.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return inv.errorf(root.Line, "expected a mapping of groups")
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		if _, err := inv.parseYAMLGroup(root.Content[i], root.Content[i+one]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) parseYAMLGroup(key, value *yaml.Node) (*inventoryGroup, error) {
+	group, err := inv.group(key.Value, key.Line)
+	if err != nil {
+		return nil, err
+	}
+	if isYAMLNull(value) {
+		return group, nil
+	}
+	if value.Kind != yaml.MappingNode {
+		return nil, inv.errorf(value.Line, "group %q must be a mapping", group.name)
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		field, content := value.Content[i], value.Content[i+one]
+		switch field.Value {
+		case "hosts":
+			err = inv.parseYAMLHosts(group, content)
+		case "vars":
+			err = inv.setYAMLVars(&group.vars, content)
+		case "children":
+			err = inv.parseYAMLChildren(group, content)
+		default:
+			err = inv.errorf(field.Line, "unknown group field %q", field.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return group, nil
+}
+
+func (inv *Inventory) parseYAMLHosts(group *inventoryGroup, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return inv.errorf(item.Line, "expected a host name")
+			}
+			inv.addHost(group, item.Value, item.Line, InventoryVars{})
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			name, value := node.Content[i], node.Content[i+one]
+			var vars InventoryVars
+			if !isYAMLNull(value) {
+				if err := inv.setYAMLVars(&vars, value); err != nil {
+					return err
+				}
+			}
+			inv.addHost(group, name.Value, name.Line, vars)
+		}
+	default:
+		if !isYAMLNull(node) {
+			return inv.errorf(node.Line, "hosts must be a mapping or a list")
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) parseYAMLChildren(group *inventoryGroup, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return inv.errorf(item.Line, "expected a group name")
+			}
+			group.children = append(group.children, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			child, err := inv.parseYAMLGroup(node.Content[i], node.Content[i+one])
+			if err != nil {
+				return err
+			}
+			group.children = append(group.children, child.name)
+		}
+	default:
+		if !isYAMLNull(node) {
+			return inv.errorf(node.Line, "children must be a mapping or a list")
+		}
+	}
+	return nil
+}
+
+func isYAMLNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func (inv *Inventory) setYAMLVars(v *InventoryVars, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return inv.errorf(node.Line, "variables must be a mapping")
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+one]
+		var err error
+		switch {
+		case key.Value == "labels" && value.Kind == yaml.MappingNode:
+			for j := 0; j < len(value.Content); j += 2 {
+				if err = v.setLabel(value.Content[j].Value, value.Content[j+one].Value); err != nil {
+					break
+				}
+			}
+		case key.Value == "identity" && value.Kind == yaml.SequenceNode:
+			v.IdentityFiles = nil
+			for _, item := range value.Content {
+				v.IdentityFiles = append(v.IdentityFiles, expandSSHConfigPath(item.Value))
+			}
+		case value.Kind == yaml.ScalarNode:
+			err = v.set(key.Value, value.Value)
+		default:
+			err = fmt.Errorf("unexpected value for %q", key.Value)
+		}
+		if err != nil {
+			return inv.errorf(key.Line, "%w", err)
+		}
+	}
+	return nil
+}
+
+func (v *InventoryVars) set(key, value string) error {
+	switch key {
+	case "user":
+		v.User = value
+	case "port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		v.Port = port
+	case "identity":
+		v.IdentityFiles = nil
+		for _, file := range ToSlice(value) {
+			v.IdentityFiles = append(v.IdentityFiles, expandSSHConfigPath(file))
+		}
+	case "jump":
+		if _, err := ParseJumpHosts(value); err != nil && !strings.EqualFold(value, "none") {
+			return err
+		}
+		v.Jump = value
+	case "proxy_command":
+		v.ProxyCommand = value
+	case "labels":
+		for _, label := range ToSlice(value) {
+			name, labelValue, _ := strings.Cut(label, "=")
+			if err := v.setLabel(name, labelValue); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown variable %q", key)
+	}
+	return nil
+}
+
+// SetLabel sets label name to value, rejecting names that are not valid
+// inventory labels.
+func (v *InventoryVars) SetLabel(name, value string) error {
+	return v.setLabel(name, value)
+}
+
+func (v *InventoryVars) setLabel(name, value string) error {
+	if !inventoryGroupPattern.MatchString(name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if v.Labels == nil {
+		v.Labels = map[string]string{}
+	}
+	v.Labels[name] = value
+	return nil
+}
+
+// merge returns v overridden by the values set in other. Labels are merged
+// key by key.
+func (v InventoryVars) merge(other InventoryVars) InventoryVars {
+	v.User = valueOr(other.User, v.User)
+	if other.Port != 0 {
+		v.Port = other.Port
+	}
+	if other.IdentityFiles != nil {
+		v.IdentityFiles = other.IdentityFiles
+	}
+	v.Jump = valueOr(other.Jump, v.Jump)
+	v.ProxyCommand = valueOr(other.ProxyCommand, v.ProxyCommand)
+	if len(other.Labels) > 0 {
+		labels := maps.Clone(v.Labels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		maps.Copy(labels, other.Labels)
+		v.Labels = labels
+	}
+	return v
+}
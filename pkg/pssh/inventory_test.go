@@ -0,0 +1,178 @@
+package pssh
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testINIInventory = `# comment
+bastion.example
+
+[web]
+web1 port=2222 labels=role=web,tier=front
+web2 user=root proxy_command="ssh -W %h:%p gw"
+
+[web:vars]
+user=deploy
+labels=env=staging
+
+[db]
+db1 identity=~/.ssh/db_key
+
+[prod:children]
+web
+db
+
+[prod:vars]
+user=ops
+labels = env=prod
+jump = bastion.example
+
+[all:vars]
+port=22
+`
+
+const testYAMLInventory = `all:
+  vars:
+    user: base
+prod:
+  vars:
+    user: ops
+    labels: {env: prod}
+  children:
+    web:
+      vars:
+        user: deploy
+      hosts:
+        web1:
+          port: 2222
+          labels:
+            role: web
+        web2:
+    db:
+      hosts:
+        - db1
+`
+
+func writeInventory(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadINIInventory(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	path := writeInventory(t, "hosts", testINIInventory)
+	if ok, err := IsInventoryFile(path); !ok || err != nil {
+		t.Fatalf("IsInventoryFile()=%t, %v", ok, err)
+	}
+	inventory, err := ReadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inventory.Groups(); !reflect.DeepEqual(got, []string{"ungrouped", "web", "db", "prod", "all"}) {
+		t.Fatalf("Groups()=%v", got)
+	}
+	hosts, err := inventory.Hosts("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("Hosts(web)=%+v", hosts)
+	}
+	web1 := hosts[0]
+	if web1.Name != "web1" || web1.Line != 5 || web1.Target() != "web1:2222" ||
+		web1.Vars.User != "deploy" || web1.Vars.Jump != "bastion.example" ||
+		!reflect.DeepEqual(web1.Groups, []string{"web", "prod"}) ||
+		!reflect.DeepEqual(web1.Vars.Labels, map[string]string{"env": "staging", "role": "web", "tier": "front"}) {
+		t.Fatalf("web1=%+v", web1)
+	}
+	if web2 := hosts[1]; web2.Vars.User != "root" || web2.Vars.ProxyCommand != "ssh -W %h:%p gw" || web2.Target() != "web2:22" {
+		t.Fatalf("web2=%+v", web2)
+	}
+	hosts, err = inventory.Hosts("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 3 || hosts[2].Vars.User != "ops" ||
+		!reflect.DeepEqual(hosts[2].Vars.IdentityFiles, []string{"/home/test/.ssh/db_key"}) {
+		t.Fatalf("Hosts(prod)=%+v", hosts)
+	}
+	hosts, err = inventory.Hosts()
+	if err != nil || len(hosts) != 4 || hosts[0].Name != "bastion.example" || hosts[0].Groups[0] != "ungrouped" {
+		t.Fatalf("Hosts()=%+v, %v", hosts, err)
+	}
+	if _, err := inventory.Hosts("missing"); err == nil || !strings.Contains(err.Error(), `unknown group "missing"`) {
+		t.Fatalf("Hosts(missing) error=%v", err)
+	}
+}
+
+func TestReadYAMLInventory(t *testing.T) {
+	path := writeInventory(t, "inventory.yaml", testYAMLInventory)
+	inventory, err := ReadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inventory.Hosts("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, host := range hosts {
+		names = append(names, host.Target())
+	}
+	if !reflect.DeepEqual(names, []string{"web1:2222", "web2", "db1"}) {
+		t.Fatalf("targets=%v", names)
+	}
+	if hosts[0].Vars.User != "deploy" || hosts[0].Line != 13 ||
+		!reflect.DeepEqual(hosts[0].Vars.Labels, map[string]string{"env": "prod", "role": "web"}) {
+		t.Fatalf("web1=%+v", hosts[0])
+	}
+	if hosts[2].Vars.User != "ops" || !reflect.DeepEqual(hosts[2].Groups, []string{"prod", "db"}) {
+		t.Fatalf("db1=%+v", hosts[2])
+	}
+}
+
+func TestInventoryErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, data, want string
+	}{
+		{"bad.ini", "[web]\nweb1 colour=blue\n", `bad.ini:2: unknown variable "colour"`},
+		{"bad.ini", "[web]\nweb1 port=0\n", `bad.ini:2: invalid port "0"`},
+		{"bad.ini", "[web]\nweb1 proxy_command=\"nc %h\n", "bad.ini:2: unterminated quote"},
+		{"bad.ini", "[web:children]\ndb\n", `bad.ini:1: group "web": unknown child group "db"`},
+		{"bad.ini", "[a:children]\nb\n[b:children]\na\n", `group "a" contains itself`},
+		{"bad.yaml", "web:\n  host: [web1]\n", `bad.yaml:2: unknown group field "host"`},
+		{"bad.yaml", "web:\n  hosts:\n    web1:\n      labels: [a]\n", `bad.yaml:4: unexpected value for "labels"`},
+	} {
+		_, err := ReadInventory(writeInventory(t, test.name, test.data))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ReadInventory(%q) error=%v, want %q", test.data, err, test.want)
+		}
+	}
+}
+
+func TestReadHostsAcceptsInventory(t *testing.T) {
+	path := writeInventory(t, "hosts", testINIInventory)
+	hosts, err := ReadHosts(path + ":db")
+	if err != nil || !reflect.DeepEqual(hosts, []string{"db1:22"}) {
+		t.Fatalf("ReadHosts()=%v, %v", hosts, err)
+	}
+	legacy := writeInventory(t, "hosts.txt", "[2001:db8::1]:22 web1\n")
+	if ok, err := IsInventoryFile(legacy); ok || err != nil {
+		t.Fatalf("IsInventoryFile(legacy)=%t, %v", ok, err)
+	}
+	hosts, err = ReadHosts(legacy)
+	if err != nil || !reflect.DeepEqual(hosts, []string{"[2001:db8::1]:22", "web1:22"}) {
+		t.Fatalf("ReadHosts(legacy)=%v, %v", hosts, err)
+	}
+	if _, err := ReadHosts(legacy + ":web"); err == nil || !strings.Contains(err.Error(), "groups require an inventory file") {
+		t.Fatalf("ReadHosts(legacy:web) error=%v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package pssh
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// JumpHost is one ProxyJump hop.
+type JumpHost struct {
+	User string
+	Addr string
+	// IdentFiles and IdentitiesOnly are the bastion's own, as Host fields
+	// are for a target, for example from its ssh_config entry.
+	IdentFiles     []string
+	IdentitiesOnly bool
+}
+
+// ParseJumpHost parses a [user@]host[:port] hop and supplies the default port.
+func ParseJumpHost(spec string) (JumpHost, error) {
+	var hop JumpHost
+	host := spec
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		hop.User, host = spec[:at], spec[at+one:]
+		if hop.User == "" {
+			return hop, fmt.Errorf("invalid jump host %q", spec)
+		}
+	}
+	addr, err := normalizeHost(host)
+	if err != nil {
+		return hop, fmt.Errorf("invalid jump host %q: %w", spec, err)
+	}
+	hop.Addr = addr
+	return hop, nil
+}
+
+// ParseJumpHosts parses a comma-separated ProxyJump chain.
+func ParseJumpHosts(specs string) ([]JumpHost, error) {
+	var hops []JumpHost
+	for _, spec := range ToSlice(specs) {
+		hop, err := ParseJumpHost(spec)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+func (j JumpHost) String() string {
+	if j.User == "" {
+		return j.Addr
+	}
+	return j.User + "@" + j.Addr
+}
+
+func jumpChainKey(hops []JumpHost) string {
+	specs := make([]string, len(hops))
+	for i, hop := range hops {
+		specs[i] = hop.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+// jumpPool shares one SSH connection per bastion chain for the whole run.
+type jumpPool struct {
+	mu    sync.Mutex
+	conns map[string]*jumpConn
+}
+
+type jumpConn struct {
+	once   sync.Once
+	client sshClientIface
+	err    error
+}
+
+// clientDialer opens TCP connections through an established SSH connection.
+type clientDialer struct {
+	client sshClientIface
+}
+
+func (d clientDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.client.DialContext(ctx, network, addr)
+}
+
+func (p *Pssh) hostJumpHosts(host Host) []JumpHost {
+	if host.JumpHosts != nil {
+		return host.JumpHosts
+	}
+	return p.JumpHosts
+}
+
+func (p *Pssh) hostProxyCommand(host Host) string {
+	command := valueOr(host.ProxyCommand, p.ProxyCommand)
+	if command == ProxyCommandNone {
+		return ""
+	}
+	return command
+}
+
+// targetDialer returns the dialer for host, starting its proxy command or
+// connecting any bastions it needs.
+func (p *Pssh) targetDialer(ctx context.Context, host Host) (sshDialIface, error) {
+	if command := p.hostProxyCommand(host); command != "" {
+		return sshDial{netDialer: proxyCommandDialer{command: command, user: valueOr(host.User, p.User)}}, nil
+	}
+	hops := p.hostJumpHosts(host)
+	if len(hops) == 0 {
+		return p.sshDialer, nil
+	}
+	client, err := p.jumpClient(ctx, hops)
+	if err != nil {
+		return nil, err
+	}
+	return sshDial{netDialer: clientDialer{client: client}}, nil
+}
+
+// jumpClient returns the shared connection to the last hop of hops. A failed
+// hop is remembered so that targets behind it fail without redialing.
+func (p *Pssh) jumpClient(ctx context.Context, hops []JumpHost) (sshClientIface, error) {
+	key := jumpChainKey(hops)
+	p.jumps.mu.Lock()
+	if p.jumps.conns == nil {
+		p.jumps.conns = map[string]*jumpConn{}
+	}
+	conn, ok := p.jumps.conns[key]
+	if !ok {
+		conn = &jumpConn{}
+		p.jumps.conns[key] = conn
+	}
+	p.jumps.mu.Unlock()
+	conn.once.Do(func() {
+		conn.client, conn.err = p.dialJumpHost(ctx, hops)
+	})
+	return conn.client, conn.err
+}
+
+func (p *Pssh) dialJumpHost(ctx context.Context, hops []JumpHost) (sshClientIface, error) {
+	dialer := p.sshDialer
+	if len(hops) > one {
+		parent, err := p.jumpClient(ctx, hops[:len(hops)-one])
+		if err != nil {
+			return nil, err
+		}
+		dialer = sshDial{netDialer: clientDialer{client: parent}}
+	}
+	hop := hops[len(hops)-one]
+	config := p.clientConf
+	config.User = valueOr(hop.User, p.User)
+	config.Auth = p.hostAuthMethods(Host{IdentFiles: hop.IdentFiles, IdentitiesOnly: hop.IdentitiesOnly})
+	if p.Debug {
+		log.Printf("start jump host ssh.Dial : %s", hop)
+	}
+	client, err := dialer.DialContext(ctx, "tcp", hop.Addr, &config)
+	if err != nil {
+		return nil, fmt.Errorf("jump host %s: %w", hop, err)
+	}
+	return client, nil
+}
+
+func (p *Pssh) closeJumpClients() {
+	p.jumps.mu.Lock()
+	defer p.jumps.mu.Unlock()
+	for _, conn := range p.jumps.conns {
+		if conn.client != nil {
+			_ = conn.client.Close()
+		}
+	}
+	p.jumps.conns = nil
+}
@@ -0,0 +1,196 @@
+package pssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+func TestParseJumpHost(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want JumpHost
+	}{
+		{"bastion", JumpHost{Addr: "bastion:22"}},
+		{"deploy@bastion:2222", JumpHost{User: "deploy", Addr: "bastion:2222"}},
+		{"a@b@[2001:db8::1]:22", JumpHost{User: "a@b", Addr: "[2001:db8::1]:22"}},
+	} {
+		got, err := ParseJumpHost(test.spec)
+		if err != nil {
+			t.Fatalf("ParseJumpHost(%q) error=%v", test.spec, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseJumpHost(%q)=%+v, want %+v", test.spec, got, test.want)
+		}
+	}
+	for _, spec := range []string{"@bastion", "bastion:0", ""} {
+		if _, err := ParseJumpHost(spec); err == nil {
+			t.Errorf("ParseJumpHost(%q) error=nil", spec)
+		}
+	}
+	hops, err := ParseJumpHosts("a@one, two:2200")
+	if err != nil || len(hops) != 2 || hops[1].Addr != "two:2200" {
+		t.Fatalf("ParseJumpHosts()=%+v, %v", hops, err)
+	}
+}
+
+type jumpSSHDial struct {
+	mu    sync.Mutex
+	calls map[string]int
+	users map[string]string
+	err   error
+	// hopErr is returned when a later hop or target is dialed through this
+	// connection.
+	hopErr error
+}
+
+func (d *jumpSSHDial) DialContext(_ context.Context, _ string, addr string, config *ssh.ClientConfig) (sshClientIface, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.calls == nil {
+		d.calls, d.users = map[string]int{}, map[string]string{}
+	}
+	d.calls[addr]++
+	d.users[addr] = config.User
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &conSSHMock{dialErr: d.hopErr}, nil
+}
+
+func TestJumpClientIsSharedAndFailureIsCached(t *testing.T) {
+	p := &Pssh{Config: &Config{Concurrency: 1, User: "global"}}
+	p.Init()
+	dialer := &jumpSSHDial{err: errors.New("connection refused")}
+	p.sshDialer = dialer
+	hops := []JumpHost{{User: "deploy", Addr: "bastion:22"}}
+	for range 3 {
+		_, err := p.jumpClient(context.Background(), hops)
+		if err == nil || !strings.Contains(err.Error(), "jump host deploy@bastion:22: connection refused") {
+			t.Fatalf("jumpClient() error=%v", err)
+		}
+	}
+	if dialer.calls["bastion:22"] != 1 || dialer.users["bastion:22"] != "deploy" {
+		t.Fatalf("calls=%v users=%v", dialer.calls, dialer.users)
+	}
+	p.closeJumpClients()
+
+	dialer.err = nil
+	for range 3 {
+		if _, err := p.jumpClient(context.Background(), []JumpHost{{Addr: "bastion:22"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dialer.calls["bastion:22"] != 2 || dialer.users["bastion:22"] != "global" {
+		t.Fatalf("calls=%v users=%v", dialer.calls, dialer.users)
+	}
+}
+
+func TestJumpChainNamesFailedHop(t *testing.T) {
+	p := &Pssh{Config: &Config{Concurrency: 1}}
+	p.Init()
+	p.sshDialer = &jumpSSHDial{hopErr: errors.New("ssh: rejected: connect failed")}
+	hops := []JumpHost{{Addr: "outer:22"}, {User: "ops", Addr: "inner:22"}}
+	_, err := p.jumpClient(context.Background(), hops)
+	if err == nil || !strings.Contains(err.Error(), "jump host ops@inner:22") {
+		t.Fatalf("jumpClient() error=%v, want inner hop named", err)
+	}
+}
+
+func TestConWorkerReportsJumpFailureAsConnectionFailure(t *testing.T) {
+	p := &Pssh{Config: &Config{Concurrency: 1}}
+	p.Init()
+	p.sshDialer = &jumpSSHDial{err: errors.New("i/o timeout")}
+	c := p.newHostConWork(0, Host{Target: "web1:22", JumpHosts: []JumpHost{{Addr: "bastion:22"}}})
+	results := make(chan *result, 1)
+	c.command <- input{results: results}
+	go c.conWorker(context.Background(), ssh.ClientConfig{})
+	select {
+	case res := <-results:
+		if res.kind != ResultConnectionFailed || !strings.Contains(res.err.Error(), "jump host bastion:22") {
+			t.Fatalf("res kind=%q err=%v", res.kind, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for result")
+	}
+}
+
+// startKeyServer returns the address of an SSH server that only accepts
+// clients authenticating with key, and opens no channels.
+func startKeyServer(t *testing.T, key ssh.PublicKey) string {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(offered.Marshal(), key.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(testSigners["ed25519"])
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					_ = channel.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestJumpHostUsesItsOwnIdentity(t *testing.T) {
+	dir := t.TempDir()
+	global, own := filepath.Join(dir, "id_global"), filepath.Join(dir, "id_bastion")
+	if err := os.WriteFile(global, testdata.PEMBytes["ed25519"], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(own, testdata.PEMBytes["ecdsa"], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &Pssh{Config: &Config{
+		Concurrency: 1, MaxAgentConns: 1, IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second,
+		IdentFiles: []string{global}, User: "deploy",
+	}}
+	p.Init()
+	p.clientConf = ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: p.Timeout} // nolint: gosec
+	hop := JumpHost{Addr: startKeyServer(t, testPublicKeys["ecdsa"])}
+	if _, err := p.jumpClient(context.Background(), []JumpHost{hop}); err == nil ||
+		!strings.Contains(err.Error(), "unable to authenticate") {
+		t.Fatalf("jumpClient() with the global key error=%v", err)
+	}
+	p.closeJumpClients()
+	hop.IdentFiles = []string{own}
+	if _, err := p.jumpClient(context.Background(), []JumpHost{hop}); err != nil {
+		t.Fatal(err)
+	}
+	p.closeJumpClients()
+}
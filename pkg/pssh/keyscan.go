@@ -0,0 +1,237 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyScanTypes maps the key types of ssh-keyscan -t to the host-key
+// algorithms offered when asking a server for that key.
+var HostKeyScanTypes = map[string][]string{
+	"ed25519": {ssh.KeyAlgoED25519},
+	"ecdsa":   {ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521},
+	"rsa":     {ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+}
+
+// DefaultHostKeyScanTypes are the key types scanned when none are given.
+var DefaultHostKeyScanTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// States of a scanned key compared with known_hosts.
+const (
+	HostKeyNew     = "new"
+	HostKeyKnown   = "known"
+	HostKeyChanged = "changed"
+)
+
+var errHostKeyRecorded = errors.New("host key recorded")
+
+// ScannedHostKey is one key presented by a scanned host. Status and Recorded
+// are set by CompareHostKeys; Recorded lists the keys of the same type that
+// known_hosts holds for a changed key.
+type ScannedHostKey struct {
+	Key      ssh.PublicKey
+	Status   string
+	Recorded []knownhosts.KnownKey
+}
+
+// HostKeyScan is the outcome of scanning one target. Addr is the address
+// that was dialed and that known_hosts lines name.
+type HostKeyScan struct {
+	Index  int
+	Target string
+	Addr   string
+	Keys   []ScannedHostKey
+	Err    error
+}
+
+// KnownHostsLine formats key as a known_hosts line for the scanned host,
+// with the host name hashed when hash is set.
+func (s HostKeyScan) KnownHostsLine(key ssh.PublicKey, hash bool) string {
+	name := knownhosts.Normalize(s.Addr)
+	if hash {
+		name = knownhosts.HashHostname(name)
+	}
+	return knownhosts.Line([]string{name}, key)
+}
+
+// ScanHostKeys fetches the host keys of hosts without authenticating to
+// them, Concurrency hosts at a time. Each key type is one handshake that
+// offers only its algorithms and ends once the server has shown its key.
+// Jump hosts, proxy commands and proxies are used as for a run; jump hosts
+// are verified and authenticated as usual. Scans are returned in the order
+// of hosts.
+func (p *Pssh) ScanHostKeys(ctx context.Context, hosts []Host, types []string) ([]HostKeyScan, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		types = DefaultHostKeyScanTypes
+	}
+	for _, name := range types {
+		if _, ok := HostKeyScanTypes[name]; !ok {
+			return nil, fmt.Errorf("unknown host key type %q", name)
+		}
+	}
+	// Only jump hosts are verified, so that a missing known_hosts file does
+	// not stop a first scan of direct targets.
+	jumpErr := p.prepareClientConfig()
+	p.setConnPool()
+	p.identFileData = p.readIdentFiles()
+	if p.sshDialer == nil {
+		p.sshDialer = sshDial{}
+	}
+	if err := p.prepareProxy(); err != nil {
+		return nil, err
+	}
+	defer p.closeJumpClients()
+	scans := make([]HostKeyScan, len(hosts))
+	slots := make(chan struct{}, p.Concurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		scans[i] = HostKeyScan{Index: i, Target: host.Target, Addr: host.DialAddr()}
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			if jumpErr != nil && p.hostProxyCommand(host) == "" && len(p.hostJumpHosts(host)) > 0 {
+				scans[i].Err = jumpErr
+				return
+			}
+			scans[i].Keys, scans[i].Err = p.scanHost(ctx, host, types)
+		})
+	}
+	wg.Wait()
+	return scans, nil
+}
+
+func (p *Pssh) scanHost(ctx context.Context, host Host, types []string) ([]ScannedHostKey, error) {
+	dialer, err := p.targetDialer(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ScannedHostKey
+	for _, name := range types {
+		var key ssh.PublicKey
+		config := ssh.ClientConfig{
+			User:              valueOr(host.User, p.User),
+			Timeout:           p.Timeout,
+			HostKeyAlgorithms: HostKeyScanTypes[name],
+			HostKeyCallback: func(_ string, _ net.Addr, offered ssh.PublicKey) error {
+				key = offered
+				return errHostKeyRecorded
+			},
+			Config: ssh.Config{KeyExchanges: p.Kex, Ciphers: p.Ciphers, MACs: p.Macs},
+		}
+		client, err := dialer.DialContext(ctx, "tcp", host.DialAddr(), &config)
+		if client != nil {
+			_ = client.Close()
+		}
+		var negotiation *ssh.AlgorithmNegotiationError
+		switch {
+		case key != nil:
+			keys = append(keys, ScannedHostKey{Key: key})
+		case errors.As(err, &negotiation) && negotiation.What == "host key":
+			// The server has no key of this type.
+		default:
+			return keys, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host key of type %s", strings.Join(types, ","))
+	}
+	return keys, nil
+}
+
+// CompareHostKeys sets the Status of every scanned key against the
+// known_hosts files of config. A key is changed when known_hosts records a
+// different key of the same type for the host, and new when it records
+// none; keys of other types do not count. Missing files are empty.
+func CompareHostKeys(config *Config, scans []HostKeyScan) error {
+	var files []string
+	for _, file := range config.EffectiveKnownHostsFiles() {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	checker, err := newHostKeyChecker(files, nil)
+	if err != nil {
+		return err
+	}
+	for i := range scans {
+		for j := range scans[i].Keys {
+			if err := checker.compare(scans[i].Addr, &scans[i].Keys[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *hostKeyChecker) compare(addr string, scanned *ScannedHostKey) error {
+	err := c.checkPlain(c.knownHosts, addr, &net.TCPAddr{}, scanned.Key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		scanned.Status = HostKeyKnown
+		return nil
+	case !errors.As(err, &keyErr):
+		return err
+	}
+	scanned.Status, scanned.Recorded = HostKeyNew, nil
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == scanned.Key.Type() {
+			scanned.Status = HostKeyChanged
+			scanned.Recorded = append(scanned.Recorded, known)
+		}
+	}
+	return nil
+}
+
+// AppendHostKeys records the new keys of scans in the first known_hosts
+// file of config, hashing host names when hash is set, and returns how many
+// lines were written. The file is locked and compared again first, so that
+// keys recorded meanwhile by a run under accept-new or another scan are not
+// duplicated. Changed keys are never written.
+func AppendHostKeys(config *Config, scans []HostKeyScan, hash bool) (int, error) {
+	path := config.EffectiveKnownHostsFiles()[0]
+	if err := createKnownHostsFile(path); err != nil {
+		return 0, err
+	}
+	written := 0
+	err := lockKnownHostsFile(path, func(file *os.File) error {
+		current, err := newHostKeyChecker([]string{path}, nil)
+		if err != nil {
+			return err
+		}
+		var lines strings.Builder
+		for _, scan := range scans {
+			for _, scanned := range scan.Keys {
+				if scanned.Status != HostKeyNew {
+					continue
+				}
+				if err := current.compare(scan.Addr, &scanned); err != nil {
+					return err
+				}
+				if scanned.Status == HostKeyNew {
+					lines.WriteString(scan.KnownHostsLine(scanned.Key, hash) + "\n")
+					written++
+				}
+			}
+		}
+		if _, err := file.WriteString(lines.String()); err != nil {
+			return fmt.Errorf("record host keys in %s: %w", path, err)
+		}
+		return file.Sync()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
@@ -0,0 +1,136 @@
+package pssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startHostKeyServer returns the address of an SSH server presenting the
+// host keys of signers that ends every connection after the handshake.
+func startHostKeyServer(t *testing.T, signers ...ssh.Signer) string {
+	t.Helper()
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	for _, signer := range signers {
+		config.AddHostKey(signer)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				if server, _, _, err := ssh.NewServerConn(conn, config); err == nil {
+					_ = server.Close()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestScanHostKeys(t *testing.T) {
+	addr := startHostKeyServer(t, testSigners["ed25519"], testSigners["rsa"])
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	_ = closed.Close()
+
+	config := &Config{
+		Concurrency: 2, MaxAgentConns: DefaultMaxAgentConns, MaxBufferMemory: DefaultMaxBufferMemory,
+		MaxSpoolSize: DefaultMaxSpoolSize, IdentityFileOnly: true, Timeout: 5 * time.Second,
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+	}
+	p := &Pssh{Config: config}
+	scans, err := p.ScanHostKeys(context.Background(), []Host{{Target: "web1:22", Addr: addr}, {Target: closedAddr}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 2 || scans[0].Err != nil || len(scans[0].Keys) != 2 || scans[1].Err == nil {
+		t.Fatalf("scans = %+v", scans)
+	}
+	if got := scans[0].Keys[0].Key.Type(); got != ssh.KeyAlgoED25519 {
+		t.Fatalf("first key = %s", got)
+	}
+	if line := scans[0].KnownHostsLine(scans[0].Keys[1].Key, false); !strings.HasPrefix(line, "["+strings.Replace(addr, ":", "]:", 1)+" ssh-rsa ") {
+		t.Fatalf("line = %q", line)
+	}
+	if _, err := p.ScanHostKeys(context.Background(), nil, []string{"dsa"}); err == nil {
+		t.Fatal("unknown key type accepted")
+	}
+	if scans, _ := p.ScanHostKeys(context.Background(), []Host{{Target: addr}}, []string{"ecdsa"}); scans[0].Err == nil ||
+		!strings.Contains(scans[0].Err.Error(), "no host key of type ecdsa") {
+		t.Fatalf("ecdsa scan = %+v", scans)
+	}
+
+	if err := CompareHostKeys(config, scans[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if scans[0].Keys[0].Status != HostKeyNew || scans[0].Keys[1].Status != HostKeyNew {
+		t.Fatalf("keys = %+v", scans[0].Keys)
+	}
+	recorded := knownhosts.Line([]string{knownhosts.Normalize(addr)}, testPublicKeys["ed25519"]) + "\n" +
+		knownhosts.Line([]string{knownhosts.Normalize(addr)}, testPublicKeys["ca"]) + "\n"
+	if err := os.WriteFile(config.KnownHostsFiles[0], []byte(recorded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompareHostKeys(config, scans[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if keys := scans[0].Keys; keys[0].Status != HostKeyKnown || keys[1].Status != HostKeyChanged || keys[1].Recorded[0].Line != 2 {
+		t.Fatalf("keys = %+v", keys)
+	}
+}
+
+func TestAppendHostKeys(t *testing.T) {
+	config := &Config{KnownHostsFiles: []string{filepath.Join(t.TempDir(), "ssh", "known_hosts")}}
+	scans := []HostKeyScan{
+		{Target: "web1:22", Addr: "web1:22", Keys: []ScannedHostKey{
+			{Key: testPublicKeys["ed25519"], Status: HostKeyNew},
+			{Key: testPublicKeys["rsa"], Status: HostKeyChanged},
+		}},
+		{Target: "web2:2222", Addr: "10.0.0.2:2222", Keys: []ScannedHostKey{{Key: testPublicKeys["ecdsa"], Status: HostKeyNew}}},
+	}
+	written, err := AppendHostKeys(config, scans, true)
+	if err != nil || written != 2 {
+		t.Fatalf("written=%d err=%v", written, err)
+	}
+	// Keys recorded meanwhile are not written twice.
+	if written, err := AppendHostKeys(config, scans, true); err != nil || written != 0 {
+		t.Fatalf("second append written=%d err=%v", written, err)
+	}
+	data, err := os.ReadFile(config.KnownHostsFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "|1|") {
+		t.Fatalf("known_hosts = %q", data)
+	}
+	callback, err := knownhosts.New(config.KnownHostsFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("10.0.0.2:2222", &net.TCPAddr{}, testPublicKeys["ecdsa"]); err != nil {
+		t.Fatalf("hashed entry: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package pssh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const redactedSecret = "[redacted]"
+
+// Secret holds a password. It formats and marshals as "[redacted]" so that
+// printing a Config, in a debug log or elsewhere, cannot reveal it.
+type Secret string
+
+func (Secret) String() string { return redactedSecret }
+
+// GoString keeps %#v from printing the value.
+func (Secret) GoString() string { return redactedSecret }
+
+// MarshalText keeps encoders from printing the value.
+func (Secret) MarshalText() ([]byte, error) { return []byte(redactedSecret), nil }
+
+// PromptFunc asks the user a keyboard-interactive question for target.
+// echo reports whether the answer may be shown while it is typed.
+type PromptFunc func(target, question string, echo bool) (string, error)
+
+// passwordAuthMethods offers the password through keyboard-interactive and
+// password authentication. Jump hosts never receive it: the password is
+// meant for the targets only.
+func (p *Pssh) passwordAuthMethods(target string) []ssh.AuthMethod {
+	if p.Password == "" {
+		return nil
+	}
+	return []ssh.AuthMethod{
+		ssh.KeyboardInteractive(p.challengeResponder(target)),
+		ssh.Password(string(p.Password)),
+	}
+}
+
+// challengeResponder answers password questions with the configured
+// password and asks Prompt for anything else, such as a one-time code.
+func (p *Pssh) challengeResponder(target string) ssh.KeyboardInteractiveChallenge {
+	return func(_, _ string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if strings.Contains(strings.ToLower(question), "password") {
+				answers[i] = string(p.Password)
+				continue
+			}
+			if p.Prompt == nil {
+				return nil, fmt.Errorf("keyboard-interactive question %q needs a terminal", strings.TrimSpace(question))
+			}
+			answer, err := p.Prompt(target, question, i < len(echos) && echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+// targetAuthMethods is hostAuthMethods followed by the password methods.
+func (p *Pssh) targetAuthMethods(host Host) []ssh.AuthMethod {
+	return append(p.hostAuthMethods(host), p.passwordAuthMethods(host.Target)...)
+}
@@ -0,0 +1,60 @@
+package pssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecretIsRedacted(t *testing.T) {
+	config := Config{User: "admin", Password: "hunter2"}
+	data, err := json.Marshal(map[string]Secret{"password": config.Password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, output := range []string{fmt.Sprintf("%v %+v %#v %s", config, config, config, config.Password), string(data)} {
+		if strings.Contains(output, "hunter2") || !strings.Contains(output, redactedSecret) {
+			t.Fatalf("output = %q", output)
+		}
+	}
+}
+
+func TestChallengeResponder(t *testing.T) {
+	var asked []string
+	p := &Pssh{Config: &Config{Password: "hunter2", Prompt: func(target, question string, echo bool) (string, error) {
+		asked = append(asked, fmt.Sprintf("%s %s %t", target, question, echo))
+		return "123456", nil
+	}}}
+	answers, err := p.challengeResponder("web1:22")("", "", []string{"Password: ", "Verification code: "}, []bool{false, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hunter2", "123456"}; !reflect.DeepEqual(answers, want) {
+		t.Fatalf("answers = %q, want %q", answers, want)
+	}
+	if want := []string{"web1:22 Verification code:  true"}; !reflect.DeepEqual(asked, want) {
+		t.Fatalf("asked = %q, want %q", asked, want)
+	}
+
+	p.Prompt = nil
+	if _, err := p.challengeResponder("web1:22")("", "", []string{"Verification code: "}, []bool{false}); err == nil ||
+		!strings.Contains(err.Error(), "needs a terminal") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestPasswordAuthMethodsOnlyForTargets(t *testing.T) {
+	p := &Pssh{Config: &Config{IdentityFileOnly: true}}
+	if methods := p.targetAuthMethods(Host{Target: "web1:22"}); len(methods) != 0 {
+		t.Fatalf("methods without a password = %d", len(methods))
+	}
+	p.Password = "hunter2"
+	if methods := p.targetAuthMethods(Host{Target: "web1:22"}); len(methods) != 2 {
+		t.Fatalf("target methods = %d, want keyboard-interactive and password", len(methods))
+	}
+	if methods := p.hostAuthMethods(Host{}); len(methods) != 0 {
+		t.Fatalf("jump host methods = %d, want none", len(methods))
+	}
+}
@@ -0,0 +1,400 @@
+package pssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyNone disables a proxy taken from the environment.
+const ProxyNone = "none"
+
+// ParseProxyURL validates a socks5://, socks5h://, http:// or https:// proxy
+// URL with optional user:password credentials and supplies the default port.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		// url.Error quotes the input, which may contain a password.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	var defaultPort string
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		defaultPort = "1080"
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be socks5, socks5h, http, or https", proxyURL.Redacted())
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", proxyURL.Redacted())
+	}
+	if proxyURL.Port() == "" {
+		proxyURL.Host = net.JoinHostPort(proxyURL.Hostname(), defaultPort)
+	}
+	return proxyURL, nil
+}
+
+// proxyDialer opens connections through a SOCKS5 or HTTP CONNECT proxy, or
+// directly to the addresses noProxy matches.
+type proxyDialer struct {
+	url     *url.URL
+	forward contextDialer
+	noProxy noProxy
+}
+
+func newProxyDialer(proxyURL *url.URL, timeout time.Duration) proxyDialer {
+	return proxyDialer{url: proxyURL, forward: &net.Dialer{Timeout: timeout}}
+}
+
+func (d proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.noProxy.matches(addr) {
+		return d.forward.DialContext(ctx, network, addr)
+	}
+	if d.url.Scheme == "socks5" {
+		// socks5:// resolves locally; socks5h:// leaves names to the proxy.
+		resolved, err := resolveLocally(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: resolve %s: %w", d.url.Redacted(), addr, err)
+		}
+		addr = resolved
+	}
+	conn, err := d.forward.DialContext(ctx, network, d.url.Host)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", d.url.Redacted(), err)
+	}
+	// Interrupt the handshake on cancellation without closing conn, so that a
+	// completed handshake can still hand it over.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(one, 0))
+	})
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	proxied, err := d.handshake(ctx, conn, addr)
+	if !stop() {
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", d.url.Redacted(), err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return proxied, nil
+}
+
+func (d proxyDialer) handshake(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	switch d.url.Scheme {
+	case "socks5", "socks5h":
+		return conn, socks5Connect(conn, d.url.User, addr)
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.url.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		return httpConnect(tlsConn, d.url.User, addr)
+	default:
+		return httpConnect(conn, d.url.User, addr)
+	}
+}
+
+// resolveLocally replaces the host name of addr with one of its addresses,
+// preferring IPv4. IP literals are returned unchanged.
+func resolveLocally(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	ip := ips[0]
+	for _, candidate := range ips {
+		if candidate.Is4() || candidate.Is4In6() {
+			ip = candidate.Unmap()
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// noProxy holds the rules of Config.NoProxy. Like
+// golang.org/x/net/http/httpproxy, it matches IP rules only against IP
+// addresses and name rules only against host names.
+type noProxy struct {
+	all      bool
+	networks []*net.IPNet
+	ips      []noProxyIP
+	domains  []noProxyDomain
+}
+
+type noProxyIP struct {
+	ip   net.IP
+	port string
+}
+
+// noProxyDomain matches host names ending in suffix, which starts with a
+// dot, and with exact also the name without it.
+type noProxyDomain struct {
+	suffix string
+	exact  bool
+	port   string
+}
+
+func parseNoProxy(value string) noProxy {
+	var rules noProxy
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			rules.all = true
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rules.networks = append(rules.networks, network)
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, port = strings.Trim(entry, "[]"), ""
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			rules.ips = append(rules.ips, noProxyIP{ip: ip, port: port})
+			continue
+		}
+		host = strings.TrimPrefix(host, "*")
+		if host == "" {
+			continue
+		}
+		exact := !strings.HasPrefix(host, ".")
+		if exact {
+			host = "." + host
+		}
+		rules.domains = append(rules.domains, noProxyDomain{suffix: host, exact: exact, port: port})
+	}
+	return rules
+}
+
+// matches reports whether addr, a host:port, is dialed without the proxy.
+func (n noProxy) matches(addr string) bool {
+	if n.all {
+		return true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range n.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		for _, rule := range n.ips {
+			if rule.ip.Equal(ip) && (rule.port == "" || rule.port == port) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range n.domains {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, rule.suffix) || rule.exact && host == rule.suffix[one:] {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+	socks5CommandConnect   = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+)
+
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect performs the RFC 1928 CONNECT handshake, authenticating with
+// RFC 1929 username/password when user is set. A host name in addr is sent
+// as is for the proxy to resolve.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portString)
+	}
+	method := byte(socks5AuthNone)
+	if user != nil {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, one, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case method:
+	case socks5AuthNoAcceptable:
+		return errors.New("no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("unexpected SOCKS authentication method %d", reply[1])
+	}
+	if method == socks5AuthPassword {
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	}
+	request := []byte{socks5Version, socks5CommandConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long for SOCKS", host)
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(append(request, socks5AddrIPv4), ip4...)
+	} else {
+		request = append(append(request, socks5AddrIPv6), ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if message, ok := socks5Replies[header[1]]; ok {
+			return fmt.Errorf("SOCKS connect to %s: %s", addr, message)
+		}
+		return fmt.Errorf("SOCKS connect to %s: reply code %d", addr, header[1])
+	}
+	var boundLength int
+	switch header[3] {
+	case socks5AddrIPv4:
+		boundLength = net.IPv4len
+	case socks5AddrIPv6:
+		boundLength = net.IPv6len
+	case socks5AddrDomain:
+		length := make([]byte, one)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLength = int(length[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLength+2))
+	return err
+}
+
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	password, _ := user.Password()
+	if len(name) > 255 || len(password) > 255 {
+		return errors.New("SOCKS username and password must be at most 255 bytes")
+	}
+	request := append([]byte{one, byte(len(name))}, name...)
+	request = append(append(request, byte(len(password))), password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS authentication failed")
+	}
+	return nil
+}
+
+// httpConnect opens a tunnel with an HTTP CONNECT request, sending Basic
+// credentials when user is set.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, response.Status)
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn returns bytes the proxy sent after its response headers, such
+// as an SSH banner, before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,186 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxProxyCommandStderr = 4 << 10
+	proxyCommandWaitDelay = time.Second
+	proxyCommandExitDelay = 500 * time.Millisecond
+	proxyCommandNetwork   = "proxy-command"
+	// ProxyCommandNone disables a ProxyCommand set in Config.
+	ProxyCommandNone = "none"
+)
+
+// diagnosticConn is implemented by connections that can explain why the SSH
+// handshake over them failed, such as the stderr of a ProxyCommand.
+type diagnosticConn interface {
+	Diagnostics() string
+}
+
+// proxyCommandDialer connects through the stdin and stdout of a local command,
+// like OpenSSH ProxyCommand. %h, %p and %r expand to the target host, port and
+// remote user, and %% to a literal percent sign.
+type proxyCommandDialer struct {
+	command string
+	user    string
+}
+
+func (d proxyCommandDialer) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	command := expandProxyCommand(d.command, host, port, d.user)
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+	conn := &proxyCommandConn{
+		stdin:  stdinWriter,
+		stdout: stdoutReader,
+		addr:   proxyCommandAddr(command),
+		stderr: &limitedBuffer{limit: maxProxyCommandStderr},
+	}
+	// nolint: gosec
+	conn.cmd = exec.CommandContext(ctx, "sh", "-c", "exec "+command)
+	conn.cmd.Stdin = stdinReader
+	conn.cmd.Stdout = stdoutWriter
+	conn.cmd.Stderr = conn.stderr
+	conn.cmd.WaitDelay = proxyCommandWaitDelay
+	err = conn.cmd.Start()
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func expandProxyCommand(command, host, port, user string) string {
+	var builder strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+one == len(command) {
+			builder.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			builder.WriteString(host)
+		case 'p':
+			builder.WriteString(port)
+		case 'r':
+			builder.WriteString(user)
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(command[i])
+		}
+	}
+	return builder.String()
+}
+
+type proxyCommandConn struct {
+	cmd       *exec.Cmd
+	stdin     *os.File
+	stdout    *os.File
+	stderr    *limitedBuffer
+	addr      proxyCommandAddr
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *proxyCommandConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *proxyCommandConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+func (c *proxyCommandConn) LocalAddr() net.Addr         { return c.addr }
+func (c *proxyCommandConn) RemoteAddr() net.Addr        { return c.addr }
+
+func (c *proxyCommandConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.stdout.SetReadDeadline(t), c.stdin.SetWriteDeadline(t))
+}
+
+func (c *proxyCommandConn) SetReadDeadline(t time.Time) error {
+	return c.stdout.SetReadDeadline(t)
+}
+
+func (c *proxyCommandConn) SetWriteDeadline(t time.Time) error {
+	return c.stdin.SetWriteDeadline(t)
+}
+
+// Close closes the command's stdin and stdout and gives it
+// proxyCommandExitDelay to exit on its own, so that stderr it writes after
+// stdout has ended is kept, before killing it. It returns once the command
+// has exited and its stderr is complete.
+func (c *proxyCommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = errors.Join(c.stdin.Close(), c.stdout.Close())
+		exited := make(chan struct{})
+		go func() {
+			_ = c.cmd.Wait()
+			close(exited)
+		}()
+		timer := time.NewTimer(proxyCommandExitDelay)
+		defer timer.Stop()
+		select {
+		case <-exited:
+			return
+		case <-timer.C:
+		}
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		<-exited
+	})
+	return c.closeErr
+}
+
+func (c *proxyCommandConn) Diagnostics() string {
+	_ = c.Close()
+	return strings.TrimSpace(c.stderr.String())
+}
+
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string { return proxyCommandNetwork }
+func (a proxyCommandAddr) String() string  { return string(a) }
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty subprocess cannot grow memory without bound.
+type limitedBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - len(b.data); room > 0 {
+		b.data = append(b.data, data[:min(room, len(data))]...)
+	}
+	return len(data), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
@@ -0,0 +1,125 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExpandProxyCommand(t *testing.T) {
+	got := expandProxyCommand("nc %h %p -u %r 100%% %x %", "web1", "2222", "deploy")
+	want := "nc web1 2222 -u deploy 100% %x %"
+	if got != want {
+		t.Fatalf("expandProxyCommand()=%q, want %q", got, want)
+	}
+}
+
+func TestProxyCommandConnRelaysStdio(t *testing.T) {
+	conn, err := proxyCommandDialer{command: "cat"}.DialContext(context.Background(), "tcp", "web1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if conn.RemoteAddr().Network() != proxyCommandNetwork || conn.RemoteAddr().String() != "cat" {
+		t.Fatalf("RemoteAddr()=%v", conn.RemoteAddr())
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
+
+func TestProxyCommandStderrInHandshakeError(t *testing.T) {
+	dialer := sshDial{netDialer: proxyCommandDialer{command: "echo no route to %h:%p >&2; exit 1"}}
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()} // nolint: gosec
+	_, err := dialer.DialContext(context.Background(), "tcp", "web1:2222", config)
+	if err == nil || !strings.Contains(err.Error(), "proxy command stderr: no route to web1:2222") {
+		t.Fatalf("DialContext() error=%v", err)
+	}
+}
+
+func TestProxyCommandCloseKillsLingeringCommand(t *testing.T) {
+	conn, err := proxyCommandDialer{command: "sh -c 'echo closing >&2; exec sleep 60'"}.DialContext(
+		context.Background(), "tcp", "web1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	began := time.Now()
+	message := conn.(diagnosticConn).Diagnostics()
+	if elapsed := time.Since(began); elapsed > proxyCommandExitDelay+proxyCommandWaitDelay+time.Second {
+		t.Fatalf("Close() took %s", elapsed)
+	}
+	if message != "closing" {
+		t.Fatalf("Diagnostics()=%q", message)
+	}
+}
+
+func TestProxyCommandKilledOnCancel(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	dialer := sshDial{netDialer: proxyCommandDialer{command: "sh -c 'echo $$ > " + pidFile + "; exec sleep 60'"}}
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()} // nolint: gosec
+	done := make(chan error, one)
+	go func() {
+		_, err := dialer.DialContext(ctx, "tcp", "web1:22", config)
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(pidFile); err == nil && strings.TrimSpace(string(data)) != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("proxy command did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("DialContext() error=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DialContext() did not return after cancel")
+	}
+}
+
+func TestTargetDialerPrefersProxyCommand(t *testing.T) {
+	p := &Pssh{Config: &Config{
+		Concurrency: 1, User: "global", ProxyCommand: "nc %h %p",
+		JumpHosts: []JumpHost{{Addr: "bastion:22"}},
+	}}
+	p.Init()
+	dialer, err := p.targetDialer(context.Background(), Host{Target: "web1:22", User: "deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := dialer.(sshDial).netDialer.(proxyCommandDialer)
+	if !ok || got.command != "nc %h %p" || got.user != "deploy" {
+		t.Fatalf("targetDialer()=%#v", dialer)
+	}
+	p.sshDialer = &jumpSSHDial{}
+	dialer, err = p.targetDialer(context.Background(), Host{Target: "web1:22", ProxyCommand: ProxyCommandNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dialer.(sshDial).netDialer.(clientDialer); !ok {
+		t.Fatalf("targetDialer() with ProxyCommandNone=%#v", dialer)
+	}
+}
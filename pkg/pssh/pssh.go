@@ -10,18 +10,17 @@ import (
 	"log"
 	"net"
 	"os"
-	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
-	pkgerrors "github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const (
@@ -192,6 +191,11 @@ func (n sshDial) DialContext(
 		if ctxErr := ctx.Err(); ctxErr != nil {
 			return nil, ctxErr
 		}
+		if diagnostic, ok := netConn.(diagnosticConn); ok {
+			if message := diagnostic.Diagnostics(); message != "" {
+				return nil, fmt.Errorf("%w (proxy command stderr: %s)", err, message)
+			}
+		}
 		return nil, err
 	}
 	if err := ctx.Err(); err != nil {
@@ -204,6 +208,7 @@ func (n sshDial) DialContext(
 type sshClientIface interface {
 	ssh.Conn
 	Dial(n, addr string) (net.Conn, error)
+	DialContext(ctx context.Context, n, addr string) (net.Conn, error)
 	DialTCP(n string, laddr, raddr *net.TCPAddr) (net.Conn, error)
 	HandleChannelOpen(channelType string) <-chan ssh.NewChannel
 	Listen(n, addr string) (net.Listener, error)
@@ -227,7 +232,52 @@ type Pssh struct {
 	cws                  []*conWork
 	clientConf           ssh.ClientConfig
 	identFileData        [][]byte
-	conns                *connPools
+	identFileCache       sync.Map
+	identities           sync.Map // contents -> *identity
+	identityPaths        sync.Map // contents -> path
+	certificatesOnce     sync.Once
+	certificates         []certificateFile
+	agentCertificates    sync.Map // agent public key -> []certificateFile
+	agent                *agentClient
+	failedTargets        atomic.Int32
+	batches              []BatchStats
+	maxFailExceeded      bool
+	order                []*conWork
+	canary               CanaryStats
+	canaryCode           int
+	canaryPending        int
+	canaryEmitted        chan struct{}
+	// deadline is when the run reaches Config.Deadline; zero without one.
+	deadline      time.Time
+	signalsSent   atomic.Int32
+	signalsExited atomic.Int32
+	jumps         jumpPool
+}
+
+// Host is one resolved SSH target. Empty fields fall back to Config values.
+type Host struct {
+	// Target is the normalized host:port reported in results.
+	Target string
+	// Addr is the host:port to dial when it differs from Target, for example
+	// after an ssh_config HostName or Port is applied.
+	Addr           string
+	User           string
+	IdentFiles     []string
+	IdentitiesOnly bool
+	// JumpHosts overrides Config.JumpHosts when non-nil; an empty non-nil
+	// slice connects directly.
+	JumpHosts []JumpHost
+	// ProxyCommand overrides Config.ProxyCommand; ProxyCommandNone disables it.
+	// A proxy command takes precedence over jump hosts.
+	ProxyCommand string
+}
+
+// DialAddr returns the address used for the TCP connection and host-key check.
+func (h Host) DialAddr() string {
+	if h.Addr != "" {
+		return h.Addr
+	}
+	return h.Target
 }
 
 // ResultOutput is a replayable, bounded-memory remote output stream.
@@ -249,8 +299,36 @@ const (
 	ResultCanceled          ResultKind = "canceled"
 	ResultOutputFailed      ResultKind = "output_failed"
 	ResultInternalFailed    ResultKind = "internal_failed"
+	// ResultSkipped is a step that did not run because the target could not
+	// be connected or, under StopOnFailure, an earlier step failed.
+	ResultSkipped ResultKind = "skipped"
+	// ResultTimeout is a command stopped by Config.CommandTimeout or
+	// Config.Deadline, or a target or step not run before the deadline.
+	ResultTimeout ResultKind = "timeout"
+)
+
+// Connection failures with a known cause. ResultConnectionFailed remains
+// for the others.
+const (
+	ResultHostKeyMismatch   ResultKind = "host_key_mismatch"
+	ResultHostKeyUnknown    ResultKind = "host_key_unknown"
+	ResultAuthFailed        ResultKind = "auth_failed"
+	ResultDNSFailed         ResultKind = "dns_failed"
+	ResultConnectTimeout    ResultKind = "connect_timeout"
+	ResultConnectionRefused ResultKind = "connection_refused"
 )
 
+// ConnectFailed reports whether k is ResultConnectionFailed or one of the
+// connection failures with a known cause.
+func (k ResultKind) ConnectFailed() bool {
+	switch k {
+	case ResultConnectionFailed, ResultHostKeyMismatch, ResultHostKeyUnknown, ResultAuthFailed,
+		ResultDNSFailed, ResultConnectTimeout, ResultConnectionRefused:
+		return true
+	}
+	return false
+}
+
 // Result is the result of one target execution.
 type Result struct {
 	Index    int
@@ -261,6 +339,12 @@ type Result struct {
 	Stdout   ResultOutput
 	Stderr   ResultOutput
 	Duration time.Duration
+	// Step is the index of the command in Config.Steps, and Steps their
+	// number; both are zero for a run of Config.Command.
+	Step  int
+	Steps int
+	// Canary marks a result of one of Config.Canaries.
+	Canary bool
 }
 
 // Config pssh config
@@ -282,8 +366,33 @@ type Config struct {
 	Timeout          time.Duration
 	KexFlag          string
 	SSHAuthSocket    string
+	// Password is offered to targets after public keys, through
+	// keyboard-interactive and password authentication.
+	Password Secret
+	// IdentityPassphrase decrypts encrypted identity files. Without it they
+	// are decrypted with a passphrase asked through Prompt.
+	IdentityPassphrase Secret
+	// Prompt asks for identity passphrases and answers keyboard-interactive
+	// questions other than the password.
+	Prompt PromptFunc
+	// ForwardAgent forwards the agent at SSHAuthSocket to every session of
+	// the targets, not of jump hosts, through at most MaxAgentConns agent
+	// connections.
+	ForwardAgent bool
 
 	IdentFiles []string
+	// CertificateFiles are user certificates offered with the identity or
+	// agent key they certify, besides each identity's own -cert.pub.
+	CertificateFiles []string
+	// HostCAFiles hold host CA keys trusted besides the @cert-authority
+	// lines of known_hosts.
+	HostCAFiles []string
+	// KnownHostsFiles replace ~/.ssh/known_hosts; the first one records new
+	// keys under HostKeyPolicyAcceptNew.
+	KnownHostsFiles []string
+	// HostKeyPolicy is one of the HostKeyPolicy constants; empty means
+	// HostKeyPolicyStrict. IgnoreHostKey forces HostKeyPolicyInsecure.
+	HostKeyPolicy string
 	// ciphers
 	Kex     []string
 	Ciphers []string
@@ -291,8 +400,49 @@ type Config struct {
 
 	// The fields below are optional injection points used by the modern CLI.
 	// Empty values preserve the legacy flag/os package behavior.
-	Targets       []string
-	Command       string
+	Targets []string
+	// Hosts takes precedence over Targets and carries per-target settings.
+	Hosts []Host
+	// JumpHosts is the bastion chain used for targets without their own.
+	JumpHosts []JumpHost
+	// ProxyCommand connects targets through a local command's stdin/stdout.
+	ProxyCommand string
+	// Proxy is a socks5://, socks5h://, http:// or https:// URL used for
+	// direct connections and the first jump host.
+	Proxy string
+	// NoProxy lists the addresses dialed without Proxy, in the NO_PROXY
+	// format: comma-separated host names, which also match their
+	// subdomains, .domains matching only subdomains, IP addresses and CIDR
+	// ranges, each with an optional :port, or "*" for all.
+	NoProxy string
+	Command string
+	// Steps replace Command with commands run in order over the single
+	// connection of each target, one session each. Every step of every
+	// target produces a Result.
+	Steps []string
+	// StopOnFailure skips the remaining Steps of a target once one fails.
+	StopOnFailure bool
+	// BatchSize starts the targets in batches of that many, or of that
+	// percentage of them; each batch finishes before the next one starts,
+	// BatchPause later. The zero value starts every target at once.
+	BatchSize  Threshold
+	BatchPause time.Duration
+	// MaxFail stops starting targets once more than that many, or that
+	// percentage, of them have failed; the rest are reported as canceled.
+	// Nil never stops.
+	MaxFail *Threshold
+	// CommandTimeout bounds each command, and Deadline the whole run; Timeout
+	// only bounds connecting. A command out of time is sent SIGTERM and its
+	// session is closed GracePeriod later. Zero disables each.
+	CommandTimeout time.Duration
+	Deadline       time.Duration
+	GracePeriod    time.Duration
+	// Canaries are the indices of the targets that run before the others.
+	// The others start only when the canary results give exit code 0 under
+	// ExitPolicy and CanaryCheck, if set, returns true; otherwise they are
+	// reported as canceled. With SortPrint the canaries are reported first.
+	Canaries      []int
+	CanaryCheck   func(CanaryStats) bool
 	Stdin         []byte
 	Stdout        io.Writer
 	Stderr        io.Writer
@@ -346,6 +496,8 @@ type input struct {
 	command string
 	stdin   string
 	results chan<- *result
+	// last is set on the final step, after which the connection closes.
+	last bool
 }
 type result struct {
 	conID     int
@@ -357,6 +509,8 @@ type result struct {
 	stderr    resultOutput
 	started   time.Time
 	duration  time.Duration
+	// canary is set by the output loop for a result of Config.Canaries.
+	canary bool
 }
 
 func (p *Pssh) newResult(conID, sessionID int) *result {
@@ -413,7 +567,20 @@ func (p *Pssh) cleanupOutputStorage() error {
 	return err
 }
 
-func readHosts(fileName string) ([]string, error) {
+// readHostList reads a hosts file in the legacy format or an inventory into
+// engine targets carrying their per-host user and options.
+func readHostList(fileName string) ([]Host, error) {
+	fileName, group := SplitInventoryPath(fileName)
+	inventory, err := IsInventoryFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if inventory {
+		return readInventoryHosts(fileName, group)
+	}
+	if group != "" {
+		return nil, fmt.Errorf("%s: groups require an inventory file", fileName)
+	}
 	// nolint: gosec
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -423,29 +590,109 @@ func readHosts(fileName string) ([]string, error) {
 		_ = file.Close()
 	}()
 
-	var result []string
+	var result []Host
+	var exclusions []HostExclusion
 	scanner := bufio.NewScanner(file)
 	for lineNumber := 1; scanner.Scan(); lineNumber++ {
-		line := strings.SplitN(scanner.Text(), "#", 2)[0]
-		for _, value := range strings.Fields(line) {
-			host, err := normalizeHost(value)
+		targets, vars, err := ParseHostLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fileName, lineNumber, err)
+		}
+		for _, target := range targets {
+			if negated, ok := strings.CutPrefix(target, "!"); ok {
+				excluded, err := ParseHostExclusions(negated, target)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", fileName, lineNumber, err)
+				}
+				exclusions = append(exclusions, excluded...)
+				continue
+			}
+			hosts, err := expandHost(InventoryHost{Name: target, Line: lineNumber, Vars: vars})
 			if err != nil {
 				return nil, fmt.Errorf("%s:%d: %w", fileName, lineNumber, err)
 			}
-			result = append(result, host)
+			result = append(result, hosts...)
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	return slices.DeleteFunc(result, func(host Host) bool {
+		_, excluded := MatchHostExclusion(exclusions, host.Target)
+		return excluded
+	}), nil
+}
+
+func readInventoryHosts(fileName, group string) ([]Host, error) {
+	inventory, err := ReadInventory(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	if group != "" {
+		groups = append(groups, group)
+	}
+	inventoryHosts, err := inventory.Hosts(groups...)
+	if err != nil {
+		return nil, err
+	}
+	var result []Host
+	for _, inventoryHost := range inventoryHosts {
+		hosts, err := expandHost(inventoryHost)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fileName, inventoryHost.Line, err)
+		}
+		result = append(result, hosts...)
+	}
 	return result, nil
 }
 
-// ReadHosts reads and normalizes the legacy hosts-file format.
+// expandHost expands the host pattern of an inventory or hosts-file entry
+// and normalizes each resulting target.
+func expandHost(inventoryHost InventoryHost) ([]Host, error) {
+	values, err := ExpandHostPattern(inventoryHost.Target())
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]Host, 0, len(values))
+	for _, value := range values {
+		user, address, err := SplitTargetUser(value)
+		if err != nil {
+			return nil, err
+		}
+		target, err := normalizeHost(address)
+		if err != nil {
+			return nil, err
+		}
+		host, err := hostWithVars(target, user, inventoryHost.Vars)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// ReadHosts reads and normalizes a hosts file in the legacy format or an
+// inventory, optionally followed by :group. A !host entry in a legacy file
+// removes that host from the result. Users and options written in the file
+// are dropped; the engine reads them through Config.Hostsfile.
 func ReadHosts(fileName string) ([]string, error) {
 	return readHosts(fileName)
 }
 
+func readHosts(fileName string) ([]string, error) {
+	hosts, err := readHostList(fileName)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, len(hosts))
+	for i, host := range hosts {
+		targets[i] = host.Target
+	}
+	return targets, nil
+}
+
 func normalizeHost(value string) (string, error) {
 	if host, port, err := net.SplitHostPort(value); err == nil {
 		if host == "" || port == "" {
@@ -476,22 +723,20 @@ func normalizeHost(value string) (string, error) {
 	return net.JoinHostPort(value, "22"), nil
 }
 
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
 // NormalizeHost normalizes a target and supplies the default SSH port.
 func NormalizeHost(value string) (string, error) {
 	return normalizeHost(value)
 }
 
 func getHostKeyCallback(insecure bool) (ssh.HostKeyCallback, error) {
-	if insecure {
-		// nolint: gosec
-		return ssh.InsecureIgnoreHostKey(), nil
-	}
-	file := path.Join(os.Getenv("HOME"), ".ssh/known_hosts")
-	cb, err := knownhosts.New(file)
-	if err != nil {
-		return nil, pkgerrors.Wrap(err, "knownhosts.New")
-	}
-	return cb, nil
+	return hostKeyCallback(&Config{IgnoreHostKey: insecure})
 }
 
 // ValidateHostKeyPolicy verifies that the configured host-key policy can be loaded.
@@ -501,15 +746,42 @@ func ValidateHostKeyPolicy(insecure bool) error {
 }
 
 func (p *Pssh) newConWork(id int, host string) *conWork {
-	c := &conWork{Pssh: p, id: id, host: host, command: make(chan input, one)}
+	return p.newHostConWork(id, Host{Target: host})
+}
+
+func (p *Pssh) newHostConWork(id int, host Host) *conWork {
+	c := &conWork{Pssh: p, id: id, host: host.Target, hostConf: host, command: make(chan input, p.stepCount())}
 	c.startSession = c.startSessionWorker
 	return c
 }
+
+func (p *Pssh) runHosts() ([]Host, error) {
+	if p.Hosts != nil {
+		return p.Hosts, nil
+	}
+	if p.Targets == nil {
+		return readHostList(p.Hostsfile)
+	}
+	hosts := make([]Host, len(p.Targets))
+	for i, target := range p.Targets {
+		hosts[i] = Host{Target: target}
+	}
+	return hosts, nil
+}
 func (p *Pssh) setConnPool() {
 	if len(p.SSHAuthSocket) == 0 {
 		return
 	}
-	p.conns = newConnPools(p.SSHAuthSocket, p.MaxAgentConns)
+	p.agent = newAgentClient(newConnPools(p.SSHAuthSocket, p.MaxAgentConns))
+}
+
+// AgentStats returns the SSH Agent requests of the last run; it is zero when
+// no agent socket is configured.
+func (p *Pssh) AgentStats() AgentStats {
+	if p.agent == nil {
+		return AgentStats{}
+	}
+	return p.agent.Stats()
 }
 
 // Run main task
@@ -529,40 +801,49 @@ func (p *Pssh) RunContext(parent context.Context) int {
 			log.Printf("cleanup output spool err: %s", err)
 		}
 	}()
-	hosts := p.Targets
-	if hosts == nil {
-		var err error
-		hosts, err = readHosts(p.Hostsfile)
-		if err != nil {
-			// nolint: errcheck,gosec
-			log.Printf("read hosts file err: %s", err)
-			return one
-		}
-	}
-	hc, err := getHostKeyCallback(p.IgnoreHostKey)
+	hosts, err := p.runHosts()
 	if err != nil {
 		// nolint: errcheck,gosec
 		log.Printf("read hosts file err: %s", err)
 		return one
 	}
-	p.setConnPool()
-	p.clientConf = ssh.ClientConfig{
-		User: p.User,
-		//Auth:            p.getAuthMethods(),
-		Timeout:         p.Timeout,
-		HostKeyCallback: hc,
-		Config:          ssh.Config{KeyExchanges: p.Kex, Ciphers: p.Ciphers, MACs: p.Macs},
+	if err := p.prepareClientConfig(); err != nil {
+		// nolint: errcheck,gosec
+		log.Printf("read hosts file err: %s", err)
+		return one
+	}
+	if err := p.prepareProxy(); err != nil {
+		log.Printf("invalid proxy: %s", err)
+		return one
 	}
+	p.setConnPool()
 	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
+	defer p.closeJumpClients()
 	p.workerWG = sync.WaitGroup{}
 
 	p.cws = make([]*conWork, len(hosts))
 	for i, host := range hosts {
-		p.cws[i] = p.newConWork(i, host)
+		p.cws[i] = p.newHostConWork(i, host)
+	}
+	p.failedTargets.Store(0)
+	p.batches, p.maxFailExceeded = nil, false
+	p.deadline = time.Time{}
+	p.signalsSent.Store(0)
+	p.signalsExited.Store(0)
+	if p.Deadline > 0 {
+		p.deadline = time.Now().Add(p.Deadline)
+	}
+	if err := p.prepareCanaries(); err != nil {
+		log.Printf("invalid canaries: %s", err)
+		return one
 	}
 	p.workerWG.Add(len(p.cws))
-	go p.launchConWorkers(ctx)
+	launched := make(chan struct{})
+	go func() {
+		defer close(launched)
+		p.launchConWorkers(ctx)
+	}()
 
 	stdin := p.Stdin
 	if stdin == nil && p.StdinFlag {
@@ -570,22 +851,34 @@ func (p *Pssh) RunContext(parent context.Context) int {
 			log.Fatal(err)
 		}
 	}
-	command := p.Command
-	if command == "" {
-		command = strings.Join(flag.Args(), " ")
-	}
-	results := make(chan *result, len(hosts))
-	in := input{
-		command: command,
-		stdin:   string(stdin),
-		results: results,
+	commands := p.Steps
+	if len(commands) == 0 {
+		command := p.Command
+		if command == "" {
+			command = strings.Join(flag.Args(), " ")
+		}
+		commands = []string{command}
 	}
+	results := make(chan *result, len(hosts)*len(commands))
 	for i := range p.cws {
-		p.cws[i].command <- in
+		for step, command := range commands {
+			p.cws[i].command <- input{
+				id:      step,
+				command: command,
+				stdin:   string(stdin),
+				results: results,
+				last:    step == len(commands)-one,
+			}
+		}
 	}
 	code := p.outputFunc()(ctx, results, p.cws)
 	cancel()
 	p.workerWG.Wait()
+	<-launched
+	if p.Debug && p.agent != nil {
+		stats := p.agent.Stats()
+		log.Printf("ssh agent: lists=%d signs=%d errors=%d wait=%s", stats.Lists, stats.Signs, stats.Errors, stats.Wait)
+	}
 
 	return code
 }
@@ -593,31 +886,75 @@ func (p *Pssh) RunContext(parent context.Context) int {
 // ProbeContext performs TCP connection, SSH handshake, host-key verification,
 // and authentication for one target without creating a remote session.
 func (p *Pssh) ProbeContext(ctx context.Context, target string) error {
+	return p.ProbeHostContext(ctx, Host{Target: target})
+}
+
+// ProbeHostContext is ProbeContext for a target with per-host settings.
+func (p *Pssh) ProbeHostContext(ctx context.Context, host Host) error {
 	if err := p.Validate(); err != nil {
 		return err
 	}
-	hostKeyCallback, err := getHostKeyCallback(p.IgnoreHostKey)
-	if err != nil {
+	if err := p.prepareClientConfig(); err != nil {
 		return err
 	}
-	p.setConnPool()
+	// Probes of several targets share one agent client, like a run.
+	if p.agent == nil {
+		p.setConnPool()
+	}
 	p.identFileData = p.readIdentFiles()
-	config := ssh.ClientConfig{
+	if p.sshDialer == nil {
+		p.sshDialer = sshDial{}
+	}
+	if err := p.prepareProxy(); err != nil {
+		return err
+	}
+	defer p.closeJumpClients()
+	config := p.clientConf
+	config.User = valueOr(host.User, p.User)
+	config.Auth = p.targetAuthMethods(host)
+	dialer, err := p.targetDialer(ctx, host)
+	if err != nil {
+		return err
+	}
+	connection, err := dialer.DialContext(ctx, "tcp", host.DialAddr(), &config)
+	if err != nil {
+		return err
+	}
+	return connection.Close()
+}
+
+// prepareClientConfig loads the host-key policy and builds the client
+// configuration shared by every target and jump host. Auth is set per host.
+func (p *Pssh) prepareClientConfig() error {
+	callback, err := hostKeyCallback(p.Config)
+	if err != nil {
+		return err
+	}
+	p.clientConf = ssh.ClientConfig{
 		User:            p.User,
 		Timeout:         p.Timeout,
-		HostKeyCallback: hostKeyCallback,
+		HostKeyCallback: callback,
 		Config:          ssh.Config{KeyExchanges: p.Kex, Ciphers: p.Ciphers, MACs: p.Macs},
 	}
-	config.Auth = p.mergeAuthMethods(p.getIdentFileAuthMethods(p.identFileData))
-	dialer := p.sshDialer
-	if dialer == nil {
-		dialer = sshDial{}
+	return nil
+}
+
+// prepareProxy routes the default dialer through Config.Proxy. An injected
+// dialer other than sshDial is left untouched.
+func (p *Pssh) prepareProxy() error {
+	if p.Proxy == "" || p.Proxy == ProxyNone {
+		return nil
 	}
-	connection, err := dialer.DialContext(ctx, "tcp", target, &config)
+	proxyURL, err := ParseProxyURL(p.Proxy)
 	if err != nil {
 		return err
 	}
-	return connection.Close()
+	if _, ok := p.sshDialer.(sshDial); ok || p.sshDialer == nil {
+		dialer := newProxyDialer(proxyURL, p.Timeout)
+		dialer.noProxy = parseNoProxy(p.NoProxy)
+		p.sshDialer = sshDial{netDialer: dialer}
+	}
+	return nil
 }
 
 func (p *Pssh) runConWorkers(ctx context.Context) int {
@@ -625,47 +962,23 @@ func (p *Pssh) runConWorkers(ctx context.Context) int {
 	return p.launchConWorkers(ctx)
 }
 
-func (p *Pssh) launchConWorkers(ctx context.Context) int {
-	for i, cw := range p.cws {
-		if ctx.Err() != nil {
-			p.finishUnlaunchedWorkers(i)
-			return i
-		}
-		if p.Concurrency > 0 {
-			select {
-			case p.concurrentGoroutines <- struct{}{}:
-			case <-ctx.Done():
-				p.finishUnlaunchedWorkers(i)
-				return i
-			}
-		}
-		go func(cw *conWork) {
-			defer p.workerWG.Done()
-			if p.Concurrency > 0 {
-				defer func() { <-p.concurrentGoroutines }()
-			}
-			cw.conWorker(ctx, p.clientConf)
-		}(cw)
-	}
-	return len(p.cws)
-}
-
-func (p *Pssh) finishUnlaunchedWorkers(start int) {
-	for range p.cws[start:] {
-		p.workerWG.Done()
-	}
+// stepCount is the number of results of each target.
+func (p *Pssh) stepCount() int {
+	return max(one, len(p.Steps))
 }
 
 func (p *Pssh) printSortResults(ctx context.Context, results chan *result, cws []*conWork) int {
 	var firstCode int
-	resSlise := make([]*result, len(cws))
+	steps := p.stepCount()
+	resSlise := make([]*result, len(cws)*steps)
 	cur := 0
-	for i := 0; i < len(cws); i++ {
+	for i := 0; i < len(resSlise); i++ {
 		select {
 		case res := <-results:
-			resSlise[res.conID] = res
+			res.canary = cws[res.conID].canary
+			resSlise[p.outputPos(cws, res.conID)*steps+res.sessionID] = res
 		L1:
-			for j := cur; j < len(cws); j++ {
+			for j := cur; j < len(resSlise); j++ {
 				if resSlise[j] == nil {
 					break L1
 				}
@@ -697,9 +1010,10 @@ func (p *Pssh) outputFunc() func(ctx context.Context, results chan *result, cws
 
 func (p *Pssh) printResults(ctx context.Context, results chan *result, cws []*conWork) int {
 	var firstCode int
-	for i := 0; i < len(cws); i++ {
+	for i := 0; i < len(cws)*p.stepCount(); i++ {
 		select {
 		case res := <-results:
+			res.canary = cws[res.conID].canary
 			printErr := p.emitResult(res, cws[res.conID].host)
 			firstCode = p.aggregateCode(firstCode, res.code)
 			if firstCode == 0 && printErr != nil {
@@ -733,6 +1047,7 @@ func (p *Pssh) emitResult(res *result, host string) error {
 	if res.duration == 0 {
 		res.duration = time.Since(res.started)
 	}
+	defer p.canaryEmit(res)
 	if p.ResultHandler == nil {
 		return p.printResult(res, host)
 	}
@@ -745,11 +1060,20 @@ func (p *Pssh) emitResult(res *result, host string) error {
 		Stdout:   res.stdout,
 		Stderr:   res.stderr,
 		Duration: res.duration,
+		Step:     res.sessionID,
+		Steps:    len(p.Steps),
+		Canary:   res.canary,
 	})
 }
 
 func (p *Pssh) printResult(res *result, host string) error {
 	var resultErr error
+	if len(p.Steps) > 0 {
+		host = fmt.Sprintf("%s step %d", host, res.sessionID+one)
+	}
+	if res.canary {
+		host += " (canary)"
+	}
 	if p.ShowHostName {
 		var c prn
 		if res.code != 0 || res.err != nil {
@@ -804,16 +1128,19 @@ func (w prnWriter) Write(data []byte) (int, error) {
 }
 
 func (p *Pssh) sshKeyAgentCallback() ssh.AuthMethod {
-	if p.conns == nil {
+	if p.agent == nil {
 		return nil
 	}
-	agentClient := newAgentClient(p.conns)
-	return ssh.PublicKeysCallback(agentClient.Signers)
+	return ssh.PublicKeysCallback(p.agentSigners)
 }
 
 func (p *Pssh) mergeAuthMethods(identMethods []ssh.AuthMethod) []ssh.AuthMethod {
+	return p.mergeHostAuthMethods(identMethods, p.IdentityFileOnly)
+}
+
+func (p *Pssh) mergeHostAuthMethods(identMethods []ssh.AuthMethod, identitiesOnly bool) []ssh.AuthMethod {
 	res := make([]ssh.AuthMethod, 0, len(identMethods)+one)
-	if !p.IdentityFileOnly {
+	if !identitiesOnly {
 		if keyAgentMehod := p.sshKeyAgentCallback(); keyAgentMehod != nil {
 			res = append(res, keyAgentMehod)
 		}
@@ -824,25 +1151,63 @@ func (p *Pssh) mergeAuthMethods(identMethods []ssh.AuthMethod) []ssh.AuthMethod
 func (p *Pssh) getIdentFileAuthMethods(identFileData [][]byte) []ssh.AuthMethod {
 	res := make([]ssh.AuthMethod, 0, len(identFileData))
 	for _, data := range identFileData {
-		key, err := ssh.ParsePrivateKey(data)
+		signers, err := p.identitySigners(data)
 		if err != nil {
 			continue
 		}
-		res = append(res, ssh.PublicKeys(key))
+		res = append(res, ssh.PublicKeys(signers...))
+	}
+	return res
+}
+
+// hostAuthMethods tries the host's own identity files before the global ones.
+func (p *Pssh) hostAuthMethods(host Host) []ssh.AuthMethod {
+	identFileData := p.identFileData
+	if len(host.IdentFiles) > 0 {
+		identFileData = append(p.readCachedIdentFiles(host.IdentFiles), identFileData...)
+	}
+	identitiesOnly := p.IdentityFileOnly || host.IdentitiesOnly
+	return p.mergeHostAuthMethods(p.getIdentFileAuthMethods(identFileData), identitiesOnly)
+}
+
+func (p *Pssh) readCachedIdentFiles(files []string) [][]byte {
+	res := make([][]byte, 0, len(files))
+	for _, filePath := range files {
+		if cached, ok := p.identFileCache.Load(filePath); ok {
+			if data := cached.([]byte); data != nil {
+				res = append(res, data)
+			}
+			continue
+		}
+		data := p.readIdentFileList([]string{filePath})
+		var buffer []byte
+		if len(data) > 0 {
+			buffer = data[0]
+			res = append(res, buffer)
+		}
+		p.identFileCache.Store(filePath, buffer)
 	}
 	return res
 }
 
+// expandTilde replaces ~ with $HOME.
+func expandTilde(path string) string {
+	return strings.Replace(path, "~", os.Getenv("HOME"), one)
+}
+
 func (p *Pssh) readIdentFiles() [][]byte {
-	res := make([][]byte, 0, len(p.IdentFiles))
-	home := os.Getenv("HOME")
-	for _, filePath := range p.IdentFiles {
-		// nolint: gosec
-		filePath = strings.Replace(filePath, "~", home, one)
+	return p.readIdentFileList(p.IdentFiles)
+}
+
+func (p *Pssh) readIdentFileList(files []string) [][]byte {
+	res := make([][]byte, 0, len(files))
+	for _, filePath := range files {
+		filePath = expandTilde(filePath)
 		buffer, err := os.ReadFile(filePath)
 		if err != nil {
 			continue
 		}
+		p.identityPaths.LoadOrStore(string(buffer), filePath)
 		res = append(res, buffer)
 	}
 	return res
@@ -660,12 +660,12 @@ func TestSshKeyAgentCallback(t *testing.T) {
 	p := &Pssh{Config: &Config{ColorMode: true}}
 	p.Init()
 	p.SSHAuthSocket = "/dev/null"
-	p.conns = nil
+	p.agent = nil
 	f := p.sshKeyAgentCallback()
 	if f != nil {
 		t.Error("f!=nil")
 	}
-	p.conns = newConnPools(p.SSHAuthSocket, p.MaxAgentConns)
+	p.agent = newAgentClient(newConnPools(p.SSHAuthSocket, p.MaxAgentConns))
 	f = p.sshKeyAgentCallback()
 	if f == nil {
 		t.Error("f==nil")
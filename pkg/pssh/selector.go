@@ -0,0 +1,159 @@
+package pssh
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Label requirement operators.
+const (
+	SelectorEquals    = "="
+	SelectorNotEquals = "!="
+	SelectorIn        = "in"
+	SelectorNotIn     = "notin"
+	SelectorExists    = "exists"
+	SelectorNotExists = "!"
+)
+
+var selectorSetPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// Selector is a conjunction of label requirements written in kubectl syntax,
+// for example "role=web,env!=prod,tier in (front,api),!canary".
+type Selector []LabelRequirement
+
+// LabelRequirement is one term of a Selector.
+type LabelRequirement struct {
+	Key    string
+	Op     string
+	Values []string
+}
+
+// ParseSelector parses a comma-separated list of requirements. Commas inside
+// the parentheses of in and notin separate values.
+func ParseSelector(expr string) (Selector, error) {
+	var selector Selector
+	for _, term := range splitSelectorTerms(expr) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("invalid selector %q: empty requirement", expr)
+		}
+		requirement, err := parseLabelRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", expr, err)
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+func splitSelectorTerms(expr string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, r := range expr {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + one
+			}
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+func parseLabelRequirement(term string) (LabelRequirement, error) {
+	var requirement LabelRequirement
+	switch {
+	case selectorSetPattern.MatchString(term):
+		match := selectorSetPattern.FindStringSubmatch(term)
+		requirement = LabelRequirement{Key: match[1], Op: match[2]}
+		for _, value := range strings.Split(match[3], ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				return requirement, fmt.Errorf("empty value in %q", term)
+			}
+			requirement.Values = append(requirement.Values, value)
+		}
+	case strings.Contains(term, "!="):
+		key, value, _ := strings.Cut(term, "!=")
+		requirement = LabelRequirement{Key: strings.TrimSpace(key), Op: SelectorNotEquals, Values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(term, "="):
+		key, value, _ := strings.Cut(term, "=")
+		value = strings.TrimPrefix(value, "=")
+		requirement = LabelRequirement{Key: strings.TrimSpace(key), Op: SelectorEquals, Values: []string{strings.TrimSpace(value)}}
+	case strings.HasPrefix(term, "!"):
+		requirement = LabelRequirement{Key: strings.TrimSpace(term[one:]), Op: SelectorNotExists}
+	default:
+		requirement = LabelRequirement{Key: term, Op: SelectorExists}
+	}
+	if !inventoryGroupPattern.MatchString(requirement.Key) {
+		return requirement, fmt.Errorf("invalid label name %q", requirement.Key)
+	}
+	return requirement, nil
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	return s.Explain(labels) == ""
+}
+
+// Explain returns "" when labels satisfy the selector, and otherwise names
+// the first requirement that fails and the label value that failed it.
+func (s Selector) Explain(labels map[string]string) string {
+	for _, requirement := range s {
+		value, ok := labels[requirement.Key]
+		if requirement.matches(value, ok) {
+			continue
+		}
+		if ok {
+			return fmt.Sprintf("%s: %s is %q", requirement, requirement.Key, value)
+		}
+		return fmt.Sprintf("%s: %s is not set", requirement, requirement.Key)
+	}
+	return ""
+}
+
+// matches follows kubectl: != and notin also match hosts without the label.
+func (r LabelRequirement) matches(value string, ok bool) bool {
+	switch r.Op {
+	case SelectorEquals:
+		return ok && value == r.Values[0]
+	case SelectorNotEquals:
+		return !ok || value != r.Values[0]
+	case SelectorIn:
+		return ok && slices.Contains(r.Values, value)
+	case SelectorNotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case SelectorExists:
+		return ok
+	case SelectorNotExists:
+		return !ok
+	}
+	return false
+}
+
+func (r LabelRequirement) String() string {
+	switch r.Op {
+	case SelectorIn, SelectorNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ","))
+	case SelectorExists:
+		return r.Key
+	case SelectorNotExists:
+		return "!" + r.Key
+	default:
+		return r.Key + r.Op + r.Values[0]
+	}
+}
+
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, requirement := range s {
+		terms[i] = requirement.String()
+	}
+	return strings.Join(terms, ",")
+}
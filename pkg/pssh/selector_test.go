@@ -0,0 +1,45 @@
+package pssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"role": "web", "env": "staging", "tier": "front"}
+	for _, test := range []struct {
+		expr string
+		want string
+	}{
+		{"role=web", ""},
+		{"role==web,env!=prod", ""},
+		{"tier in (front, api),canary notin (yes)", ""},
+		{"role,!canary", ""},
+		{"role=db", `role=db: role is "web"`},
+		{"env!=staging", `env!=staging: env is "staging"`},
+		{"zone in (a,b)", "zone in (a,b): zone is not set"},
+		{"tier notin (front)", `tier notin (front): tier is "front"`},
+		{"canary", "canary: canary is not set"},
+		{"role=web,!tier", `!tier: tier is "front"`},
+	} {
+		selector, err := ParseSelector(test.expr)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q) error=%v", test.expr, err)
+		}
+		if got := selector.Explain(labels); got != test.want {
+			t.Errorf("ParseSelector(%q).Explain()=%q, want %q", test.expr, got, test.want)
+		}
+		if selector.Matches(labels) != (test.want == "") {
+			t.Errorf("ParseSelector(%q).Matches() disagrees with Explain", test.expr)
+		}
+	}
+	for _, expr := range []string{"", "role=web,", "bad key=x", "tier in (a,,b)", "!"} {
+		if _, err := ParseSelector(expr); err == nil || !strings.Contains(err.Error(), "invalid selector") {
+			t.Errorf("ParseSelector(%q) error=%v", expr, err)
+		}
+	}
+	selector, _ := ParseSelector("tier in (a, b),env!=prod,!x")
+	if got := selector.String(); got != "tier in (a,b),env!=prod,!x" {
+		t.Errorf("String()=%q", got)
+	}
+}
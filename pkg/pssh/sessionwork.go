@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type sess interface {
@@ -15,6 +16,7 @@ type sess interface {
 	StdoutPipe() (io.Reader, error)
 	Start(cmd string) error
 	Wait() error
+	Signal(sig ssh.Signal) error
 	Close() error
 }
 
@@ -83,11 +85,22 @@ func (s *sessionWork) run(ctx context.Context, res *result, session sess) {
 		go func() {
 			waitCh <- session.Wait()
 		}()
+		expired, stopTimer, timeoutErr := s.con.commandTimer()
+		defer stopTimer()
 
 		var waitErr error
 		var interrupted bool
 		select {
 		case waitErr = <-waitCh:
+		case <-expired:
+			// The output read so far is kept.
+			interrupted = true
+			res.kind = ResultTimeout
+			res.code = timeoutCode
+			errs[3].err = timeoutErr
+			if stopErr := s.timeoutSession(ctx, session, waitCh); stopErr != nil {
+				errs[3].err = errors.Join(errs[3].err, stopErr)
+			}
 		case outputErr := <-res.stdout.Fatal():
 			interrupted = true
 			res.kind = ResultOutputFailed
@@ -108,10 +121,9 @@ func (s *sessionWork) run(ctx context.Context, res *result, session sess) {
 			interrupted = true
 			res.kind = ResultCanceled
 			errs[3].err = ctx.Err()
-			if closeErr := session.Close(); closeErr != nil {
-				errs[3].err = errors.Join(errs[3].err, closeErr)
+			if stopErr := s.interruptSession(ctx, session, waitCh); stopErr != nil {
+				errs[3].err = errors.Join(errs[3].err, stopErr)
 			}
-			<-waitCh
 		}
 		if !interrupted && waitErr != nil {
 			if ee, ok := waitErr.(*ssh.ExitError); ok {
@@ -166,18 +178,31 @@ func getAllError(errs []sessErr) error {
 
 func (s *sessionWork) worker(ctx context.Context, conn client) {
 	res := s.newResult()
+	if s.con.deadlinePassed() {
+		res.kind = ResultTimeout
+		res.code = timeoutCode
+		s.result(ctx, fmt.Errorf("not started: %w", s.con.deadlineErr()), res)
+		return
+	}
 	session, err := conn.NewSession()
 	if err != nil {
 		res.kind = ResultRemoteStartFailed
 		s.result(ctx, fmt.Errorf("cannot open new session: %v", err), res)
 		return
 	}
+	if s.con.ForwardAgent {
+		// As with ssh -A, the command still runs when the server refuses.
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			s.con.warnf("%s: agent forwarding refused: %v", s.con.host, err)
+		}
+	}
 	// nolint: errcheck
 	session.Stdin = strings.NewReader(s.stdin)
 	s.runner(ctx, res, session)
 }
 
 func (s *sessionWork) errResult(ctx context.Context, res *result) {
+	s.con.recordResult(res)
 	if ctx.Err() != nil {
 		_ = s.con.delReslt(res)
 		return
@@ -28,8 +28,9 @@ func (s *mockSess) Start(cmd string) error {
 	s.started = true
 	return nil
 }
-func (s *mockSess) Wait() error  { return s.err }
-func (s *mockSess) Close() error { return nil }
+func (s *mockSess) Wait() error             { return s.err }
+func (s *mockSess) Signal(ssh.Signal) error { return nil }
+func (s *mockSess) Close() error            { return nil }
 
 func (s *mockSess) runner(ctx context.Context, res *result, session sess) {
 	s.res = res
@@ -102,6 +103,8 @@ func (s *blockingSess) Wait() error {
 	return errors.New("session closed")
 }
 
+func (s *blockingSess) Signal(ssh.Signal) error { return nil }
+
 func (s *blockingSess) Close() error {
 	s.closeOnce.Do(func() {
 		close(s.done)
@@ -0,0 +1,101 @@
+package pssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignalCause is the cause of a run canceled by a local signal. When the
+// context of RunContext is canceled with it, the running commands are sent
+// Signal and get Config.GracePeriod to exit before their sessions are
+// closed; any other cancellation closes them at once.
+type SignalCause struct {
+	// Name is the local signal, as in "received signal: interrupt".
+	Name   string
+	Signal ssh.Signal
+	// Force, once closed, ends the grace period at once.
+	Force <-chan struct{}
+}
+
+func (c *SignalCause) Error() string {
+	return "received signal: " + c.Name
+}
+
+// SignalStats counts the commands of the last run sent a forwarded signal,
+// and those of them that exited before their session was closed.
+type SignalStats struct {
+	Sent   int
+	Exited int
+}
+
+// SignalStats returns the forwarded signals of the last run.
+func (p *Pssh) SignalStats() SignalStats {
+	return SignalStats{Sent: int(p.signalsSent.Load()), Exited: int(p.signalsExited.Load())}
+}
+
+// interruptSession ends the session of a canceled run, forwarding the signal
+// of a SignalCause first.
+func (s *sessionWork) interruptSession(ctx context.Context, session sess, waitCh <-chan error) error {
+	var cause *SignalCause
+	if !errors.As(context.Cause(ctx), &cause) {
+		return s.closeSession(session, waitCh)
+	}
+	s.con.signalsSent.Add(one)
+	exited, err := s.stopSession(session, waitCh, cause.Signal, cause.Force)
+	if exited {
+		s.con.signalsExited.Add(one)
+	}
+	return err
+}
+
+// timeoutSession ends the session of a command that ran out of time with
+// SIGTERM and GracePeriod. A run canceled meanwhile ends it as
+// interruptSession does, forwarding a signal and honoring its Force.
+func (s *sessionWork) timeoutSession(ctx context.Context, session sess, waitCh <-chan error) error {
+	if err := session.Signal(ssh.SIGTERM); err != nil || s.con.GracePeriod <= 0 {
+		return s.closeSession(session, waitCh)
+	}
+	grace := time.NewTimer(s.con.GracePeriod)
+	defer grace.Stop()
+	select {
+	case <-waitCh:
+		return nil
+	case <-grace.C:
+		return s.closeSession(session, waitCh)
+	case <-ctx.Done():
+		return s.interruptSession(ctx, session, waitCh)
+	}
+}
+
+// stopSession sends sig to the remote command and closes the session when
+// the command has not exited within GracePeriod or before force is closed.
+// It reports whether the command exited first, and returns once waitCh has
+// delivered the result of session.Wait, which it discards.
+func (s *sessionWork) stopSession(session sess, waitCh <-chan error, sig ssh.Signal, force <-chan struct{}) (bool, error) {
+	// A server without signal support ignores the request, so the command
+	// only ends when the session closes.
+	if err := session.Signal(sig); err == nil && s.con.GracePeriod > 0 {
+		grace := time.NewTimer(s.con.GracePeriod)
+		defer grace.Stop()
+		select {
+		case <-waitCh:
+			return true, nil
+		case <-grace.C:
+		case <-force:
+		}
+	}
+	return false, s.closeSession(session, waitCh)
+}
+
+func (s *sessionWork) closeSession(session sess, waitCh <-chan error) error {
+	err := session.Close()
+	<-waitCh
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
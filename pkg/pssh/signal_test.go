@@ -0,0 +1,104 @@
+package pssh
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardSignal(t *testing.T) {
+	for name, force := range map[string]bool{"grace": false, "force": true} {
+		t.Run(name, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			received := make(chan string, 2)
+			exits := startSessionServer(t, func(s *testSession) int {
+				started <- struct{}{}
+				signal := <-s.signals
+				received <- signal
+				return 130
+			})
+			ignores := startSessionServer(t, func(s *testSession) int {
+				started <- struct{}{}
+				<-s.closed
+				return 0
+			})
+			grace := time.Second
+			if force {
+				grace = 10 * time.Second
+			}
+			p := &Pssh{Config: &Config{
+				Concurrency: 5, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+				IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, GracePeriod: grace,
+				Hosts: []Host{{Target: exits}, {Target: ignores}}, Command: "sleep 600", Stdout: io.Discard, Stderr: io.Discard,
+			}}
+			p.Init()
+			ctx, cancel := context.WithCancelCause(context.Background())
+			done := make(chan int)
+			go func() { done <- p.RunContext(ctx) }()
+			<-started
+			<-started
+			forceCh := make(chan struct{})
+			interrupted := time.Now()
+			cancel(&SignalCause{Name: "interrupt", Signal: ssh.SIGINT, Force: forceCh})
+			if signal := <-received; signal != "INT" {
+				t.Fatalf("signal=%q", signal)
+			}
+			if force {
+				// Force once the first command has exited, so that exactly
+				// one exits within the grace period.
+				for p.SignalStats().Exited == 0 && time.Since(interrupted) < grace {
+					time.Sleep(10 * time.Millisecond)
+				}
+				close(forceCh)
+			}
+			if code := <-done; code != 1 {
+				t.Fatalf("code=%d", code)
+			}
+			elapsed := time.Since(interrupted)
+			stats := p.SignalStats()
+			if stats.Sent != 2 || stats.Exited != 1 || force && elapsed >= grace/2 || !force && elapsed < grace {
+				t.Fatalf("stats=%+v after %s", stats, elapsed)
+			}
+		})
+	}
+}
+
+func TestForwardSignalDuringTimeoutGrace(t *testing.T) {
+	received := make(chan string, 2)
+	target := startSessionServer(t, func(s *testSession) int {
+		received <- <-s.signals
+		received <- <-s.signals
+		<-s.closed
+		return 0
+	})
+	p := &Pssh{Config: &Config{
+		Concurrency: 1, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+		IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second,
+		CommandTimeout: 100 * time.Millisecond, GracePeriod: 10 * time.Second,
+		Hosts: []Host{{Target: target}}, Command: "sleep 600", Stdout: io.Discard, Stderr: io.Discard,
+	}}
+	p.Init()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan int)
+	go func() { done <- p.RunContext(ctx) }()
+	if signal := <-received; signal != "TERM" {
+		t.Fatalf("timeout signal=%q", signal)
+	}
+	forceCh := make(chan struct{})
+	interrupted := time.Now()
+	cancel(&SignalCause{Name: "interrupt", Signal: ssh.SIGINT, Force: forceCh})
+	if signal := <-received; signal != "INT" {
+		t.Fatalf("forwarded signal=%q", signal)
+	}
+	close(forceCh)
+	if code := <-done; code != 1 {
+		t.Fatalf("code=%d", code)
+	}
+	if elapsed, stats := time.Since(interrupted), p.SignalStats(); stats.Sent != 1 || stats.Exited != 0 ||
+		elapsed >= p.GracePeriod/2 {
+		t.Fatalf("stats=%+v after %s", stats, elapsed)
+	}
+}
@@ -0,0 +1,241 @@
+package pssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const maxSSHConfigIncludeDepth = 16
+
+// SSHConfig is the subset of an OpenSSH client configuration file that gopssh
+// uses to resolve targets. Match blocks are ignored.
+type SSHConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	key   string
+	value string
+}
+
+// SSHHostConfig holds the values an ssh_config file resolves for one host.
+// Empty values mean the file did not set the option.
+type SSHHostConfig struct {
+	HostName       string
+	Port           int
+	User           string
+	IdentityFiles  []string
+	IdentitiesOnly bool
+	// ProxyJump is the raw comma-separated chain, or "none".
+	ProxyJump string
+	// ProxyCommand is the raw command line, or "none".
+	ProxyCommand string
+}
+
+// DefaultSSHConfigPath returns the per-user OpenSSH client configuration path.
+func DefaultSSHConfigPath() string {
+	return path.Join(os.Getenv("HOME"), ".ssh/config")
+}
+
+// ReadSSHConfig parses an OpenSSH client configuration file, following
+// Include directives.
+func ReadSSHConfig(fileName string) (*SSHConfig, error) {
+	c := &SSHConfig{blocks: []sshConfigBlock{{patterns: []string{"*"}}}}
+	if err := c.readFile(fileName, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SSHConfig) readFile(fileName string, depth int) error {
+	// nolint: gosec
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return c.parse(file, fileName, depth)
+}
+
+func (c *SSHConfig) parse(r io.Reader, fileName string, depth int) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		key, value, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", fileName, lineNumber, err)
+		}
+		switch key {
+		case "":
+		case "host":
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: strings.Fields(value)})
+		case "match":
+			c.blocks = append(c.blocks, sshConfigBlock{})
+		case "include":
+			if err := c.include(value, depth); err != nil {
+				return fmt.Errorf("%s:%d: %w", fileName, lineNumber, err)
+			}
+		default:
+			last := &c.blocks[len(c.blocks)-1]
+			last.options = append(last.options, sshConfigOption{key: key, value: value})
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *SSHConfig) include(value string, depth int) error {
+	if depth >= maxSSHConfigIncludeDepth {
+		return errors.New("too many nested Include directives")
+	}
+	for _, pattern := range strings.Fields(value) {
+		pattern = expandSSHConfigPath(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := c.readFile(match, depth+one); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func splitSSHConfigLine(line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", "", fmt.Errorf("missing value for %q", line)
+	}
+	key := strings.ToLower(line[:end])
+	value := strings.TrimSpace(line[end:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("missing value for %q", key)
+	}
+	return key, value, nil
+}
+
+// Lookup resolves the options that apply to host. As in OpenSSH, the first
+// value obtained for each option wins, while IdentityFile accumulates.
+func (c *SSHConfig) Lookup(host string) (SSHHostConfig, error) {
+	var result SSHHostConfig
+	if c == nil {
+		return result, nil
+	}
+	seen := map[string]bool{}
+	for _, block := range c.blocks {
+		if !matchSSHConfigPatterns(block.patterns, host) {
+			continue
+		}
+		for _, option := range block.options {
+			if option.key != "identityfile" && seen[option.key] {
+				continue
+			}
+			seen[option.key] = true
+			if err := result.set(option, host); err != nil {
+				return result, fmt.Errorf("ssh_config for %s: %w", host, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (h *SSHHostConfig) set(option sshConfigOption, host string) error {
+	switch option.key {
+	case "hostname":
+		h.HostName = strings.ReplaceAll(option.value, "%h", host)
+	case "port":
+		port, err := strconv.Atoi(option.value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid Port %q", option.value)
+		}
+		h.Port = port
+	case "user":
+		h.User = option.value
+	case "identityfile":
+		if !strings.EqualFold(option.value, "none") {
+			h.IdentityFiles = append(h.IdentityFiles, expandSSHConfigPath(option.value))
+		}
+	case "identitiesonly":
+		h.IdentitiesOnly = strings.EqualFold(option.value, "yes")
+	case "proxyjump":
+		h.ProxyJump = option.value
+	case "proxycommand":
+		h.ProxyCommand = option.value
+	}
+	return nil
+}
+
+func expandSSHConfigPath(value string) string {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		return os.Getenv("HOME") + value[1:]
+	}
+	return value
+}
+
+// matchSSHConfigPatterns reports whether host matches a Host line. A negated
+// pattern that matches excludes the host regardless of the other patterns.
+func matchSSHConfigPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if !matchSSHConfigPattern(strings.ToLower(pattern), strings.ToLower(host)) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func matchSSHConfigPattern(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(value); i >= 0; i-- {
+				if matchSSHConfigPattern(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || pattern[0] != value[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		value = value[1:]
+	}
+	return value == ""
+}
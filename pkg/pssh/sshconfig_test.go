@@ -0,0 +1,105 @@
+package pssh
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSSHConfig(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSSHConfigLookup(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	writeSSHConfig(t, dir, "extra.conf", "Host db*\n  User dba\n")
+	file := writeSSHConfig(t, dir, "config", `# fleet
+Include `+filepath.Join(dir, "extra.conf")+`
+Host web? !web9
+  HostName %h.internal.example.com
+  Port 2222
+  IdentityFile ~/.ssh/web_ed25519
+  IdentitiesOnly yes
+
+Host web1
+  Port 2200
+  User web-admin
+
+Match host web1
+  User never-used
+
+Host *
+  User=deploy
+  IdentityFile "~/.ssh/id_ed25519"
+`)
+	config, err := ReadSSHConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want SSHHostConfig
+	}{
+		{"web1", SSHHostConfig{
+			HostName: "web1.internal.example.com", Port: 2222, User: "web-admin",
+			IdentityFiles:  []string{dir + "/.ssh/web_ed25519", dir + "/.ssh/id_ed25519"},
+			IdentitiesOnly: true,
+		}},
+		{"web9", SSHHostConfig{User: "deploy", IdentityFiles: []string{dir + "/.ssh/id_ed25519"}}},
+		{"db1", SSHHostConfig{User: "dba", IdentityFiles: []string{dir + "/.ssh/id_ed25519"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			got, err := config.Lookup(test.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Lookup(%q)=%+v, want %+v", test.host, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSSHConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadSSHConfig(writeSSHConfig(t, dir, "missing-value", "Host\n")); err == nil {
+		t.Error("ReadSSHConfig() error=nil for a keyword without value")
+	}
+	config, err := ReadSSHConfig(writeSSHConfig(t, dir, "bad-port", "Host *\nPort 70000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Lookup("host1"); err == nil {
+		t.Error("Lookup() error=nil for an invalid Port")
+	}
+	if _, err := ReadSSHConfig(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadSSHConfig() error=nil for a missing file")
+	}
+}
+
+func TestMatchSSHConfigPatterns(t *testing.T) {
+	for _, test := range []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{[]string{"*"}, "anything", true},
+		{[]string{"web-??"}, "web-01", true},
+		{[]string{"web-??"}, "web-1", false},
+		{[]string{"*.example.com", "!bad.example.com"}, "bad.example.com", false},
+		{[]string{"*.example.com", "!bad.example.com"}, "good.EXAMPLE.com", true},
+		{[]string{"!bad"}, "good", false},
+	} {
+		if got := matchSSHConfigPatterns(test.patterns, test.host); got != test.want {
+			t.Errorf("match(%q, %q)=%t, want %t", test.patterns, test.host, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package pssh
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSession is one session of startSessionServer.
+type testSession struct {
+	conn         *ssh.ServerConn
+	channel      ssh.Channel
+	command      string
+	agentRequest bool
+	// signals receives the names of the signals sent to the session, and
+	// closed is closed when the client closes it.
+	signals chan string
+	closed  chan struct{}
+}
+
+// startSessionServer returns the address of an SSH server that accepts any
+// client, runs exec requests through handle and reports its result as the
+// exit status.
+func startSessionServer(t *testing.T, handle func(*testSession) int) string {
+	t.Helper()
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(testSigners["ed25519"])
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, config, handle)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveTestConn(conn net.Conn, config *ssh.ServerConfig, handle func(*testSession) int) {
+	server, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		session := &testSession{conn: server, channel: channel, signals: make(chan string, 1), closed: make(chan struct{})}
+		go func() {
+			defer close(session.closed)
+			for request := range channelRequests {
+				switch request.Type {
+				case "signal":
+					var payload struct{ Signal string }
+					_ = ssh.Unmarshal(request.Payload, &payload)
+					session.signals <- payload.Signal
+				case "auth-agent-req@openssh.com":
+					session.agentRequest = true
+					_ = request.Reply(true, nil)
+				case "exec":
+					var payload struct{ Command string }
+					_ = ssh.Unmarshal(request.Payload, &payload)
+					session.command = payload.Command
+					_ = request.Reply(true, nil)
+					go func() {
+						status := make([]byte, 4)
+						binary.BigEndian.PutUint32(status, uint32(handle(session)))
+						_, _ = channel.SendRequest("exit-status", false, status)
+						_ = channel.Close()
+					}()
+				default:
+					_ = request.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
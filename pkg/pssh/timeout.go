@@ -0,0 +1,46 @@
+package pssh
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeoutCode is the exit code of a ResultTimeout, as with timeout(1).
+const timeoutCode = 124
+
+// commandTimer returns a channel that fires when a command started now runs
+// out of time, by CommandTimeout or the run Deadline, with the error it then
+// reports. A nil channel never fires; stop releases the timer.
+func (p *Pssh) commandTimer() (expired <-chan time.Time, stop func() bool, err error) {
+	timeout := p.CommandTimeout
+	err = fmt.Errorf("command timed out after %s", timeout)
+	if !p.deadline.IsZero() {
+		if remaining := time.Until(p.deadline); timeout == 0 || remaining < timeout {
+			timeout, err = remaining, p.deadlineErr()
+		}
+	}
+	if timeout == 0 && p.deadline.IsZero() {
+		return nil, func() bool { return false }, nil
+	}
+	timer := time.NewTimer(timeout)
+	return timer.C, timer.Stop, err
+}
+
+// deadlineTimer returns a channel that fires at the run Deadline; without
+// one it is nil and never fires. stop releases the timer.
+func (p *Pssh) deadlineTimer() (expired <-chan time.Time, stop func() bool) {
+	if p.deadline.IsZero() {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(time.Until(p.deadline))
+	return timer.C, timer.Stop
+}
+
+// deadlinePassed reports whether the run Deadline has passed.
+func (p *Pssh) deadlinePassed() bool {
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+func (p *Pssh) deadlineErr() error {
+	return fmt.Errorf("run deadline of %s exceeded", p.Deadline)
+}
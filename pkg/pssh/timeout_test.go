@@ -0,0 +1,129 @@
+package pssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	stops := startSessionServer(t, func(s *testSession) int {
+		_, _ = io.WriteString(s.channel, "partial\n")
+		if signal := <-s.signals; signal != "TERM" {
+			return 1
+		}
+		return 143
+	})
+	hangs := startSessionServer(t, func(s *testSession) int {
+		<-s.closed
+		return 0
+	})
+	var got []string
+	durations := map[int]time.Duration{}
+	p := &Pssh{Config: &Config{
+		Concurrency: 5, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+		IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+		Hosts:          []Host{{Target: stops}, {Target: hangs}},
+		CommandTimeout: 100 * time.Millisecond, GracePeriod: 2 * time.Second,
+		Command: "sleep 600", Stdout: io.Discard, Stderr: io.Discard,
+		ResultHandler: func(r *Result) error {
+			var stdout bytes.Buffer
+			_, _ = r.Stdout.WriteTo(&stdout)
+			got = append(got, fmt.Sprintf("%s %d %q %v", r.Kind, r.ExitCode, stdout.String(), r.Err))
+			durations[r.Index] = r.Duration
+			return nil
+		},
+	}}
+	p.Init()
+	if code := p.Run(); code != timeoutCode {
+		t.Fatalf("code=%d", code)
+	}
+	want := []string{
+		`timeout 124 "partial\n" I/O err:command timed out after 100ms`,
+		`timeout 124 "" I/O err:command timed out after 100ms`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("results=%q", got)
+	}
+	// The session that ignores SIGTERM is closed after the grace period.
+	if durations[0] >= p.GracePeriod || durations[1] < p.CommandTimeout+p.GracePeriod {
+		t.Fatalf("durations=%v", durations)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	addr := startSessionServer(t, func(s *testSession) int {
+		<-s.closed
+		return 0
+	})
+	var got []string
+	p := &Pssh{Config: &Config{
+		Concurrency: 1, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+		IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+		Hosts: []Host{{Target: addr}, {Target: addr}}, Steps: []string{"hang", "next"},
+		CommandTimeout: time.Minute, Deadline: time.Second,
+		Stdout: io.Discard, Stderr: io.Discard,
+		ResultHandler: func(r *Result) error {
+			got = append(got, fmt.Sprintf("%d/%d %s %v", r.Index, r.Step, r.Kind, r.Err))
+			return nil
+		},
+	}}
+	p.Init()
+	started := time.Now()
+	if code := p.Run(); code != timeoutCode || time.Since(started) > 3*p.Deadline {
+		t.Fatalf("code=%d after %s", code, time.Since(started))
+	}
+	want := []string{
+		"0/0 timeout I/O err:run deadline of 1s exceeded",
+		"0/1 timeout not started: run deadline of 1s exceeded",
+		"1/0 timeout not started: run deadline of 1s exceeded",
+		"1/1 timeout not started: run deadline of 1s exceeded",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("results=%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestDeadlineEndsWaits(t *testing.T) {
+	addr := startSessionServer(t, func(*testSession) int { return 0 })
+	block := make(chan struct{})
+	defer close(block)
+	for name, setup := range map[string]func(*Config){
+		"batch pause": func(c *Config) {
+			c.BatchSize, c.BatchPause = Threshold{Value: 1}, time.Hour
+		},
+		"canary check": func(c *Config) {
+			c.Canaries = []int{0}
+			c.CanaryCheck = func(CanaryStats) bool {
+				<-block
+				return true
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			p := &Pssh{Config: &Config{
+				Concurrency: 5, MaxAgentConns: 1, MaxBufferMemory: DefaultMaxBufferMemory, MaxSpoolSize: DefaultMaxSpoolSize,
+				IgnoreHostKey: true, IdentityFileOnly: true, Timeout: 5 * time.Second, SortPrint: true,
+				Hosts: []Host{{Target: addr}, {Target: addr}}, Deadline: time.Second, Command: "true",
+				Stdout: io.Discard, Stderr: io.Discard,
+				ResultHandler: func(r *Result) error {
+					got = append(got, fmt.Sprintf("%d %s %v", r.Index, r.Kind, r.Err))
+					return nil
+				},
+			}}
+			setup(p.Config)
+			p.Init()
+			started := time.Now()
+			code := p.Run()
+			if code != timeoutCode || time.Since(started) > 3*p.Deadline || len(got) != 2 ||
+				got[1] != "1 timeout not started: run deadline of 1s exceeded" {
+				t.Fatalf("code=%d after %s results=%q", code, time.Since(started), got)
+			}
+		})
+	}
+}